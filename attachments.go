@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/zenazn/goji/web"
+)
+
+// Attachment is an uploaded file. Entries keep it as a value starting with
+// attachmentPrefix so their values stay plain strings in every store.
+type Attachment struct {
+	Key  string
+	Name string
+	Type string
+	Size int64
+}
+
+const attachmentPrefix = "formic-attachment:"
+
+func (a Attachment) value() string {
+	data, _ := json.Marshal(a)
+	return attachmentPrefix + string(data)
+}
+
+// parseAttachment returns the attachment value holds, or nil if it's just
+// text.
+func parseAttachment(value string) *Attachment {
+	if !strings.HasPrefix(value, attachmentPrefix) {
+		return nil
+	}
+	var a Attachment
+	if err := json.Unmarshal([]byte(value[len(attachmentPrefix):]), &a); err != nil {
+		return nil
+	}
+	return &a
+}
+
+// entryAttachments returns the attachments in an entry value.
+func entryAttachments(value string) []Attachment {
+	var attachments []Attachment
+	for _, item := range parseList(value) {
+		if a := parseAttachment(item); a != nil {
+			attachments = append(attachments, *a)
+		}
+	}
+	return attachments
+}
+
+func parseUploads(form *Form, values url.Values) error {
+	form.MaxUploadMB = 0
+	if v := strings.TrimSpace(values.Get("maxUploadMB")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return errors.New("Max upload size must be a non-negative number")
+		}
+		form.MaxUploadMB = n
+	}
+	types := splitList(values.Get("uploadTypes"))
+	for _, t := range types {
+		if !strings.Contains(t, "/") {
+			return fmt.Errorf("%s isn't a file type like image/png or image/*", t)
+		}
+	}
+	form.UploadTypes = strings.Join(types, ", ")
+	return nil
+}
+
+// typeAllowed reports whether contentType matches one of the form's upload
+// types, which may end in /* to match any subtype. Forms without upload
+// types take any file.
+func typeAllowed(form Form, contentType string) bool {
+	types := splitList(form.UploadTypes)
+	if len(types) == 0 {
+		return true
+	}
+	for _, t := range types {
+		if t == contentType ||
+			strings.HasSuffix(t, "/*") && strings.HasPrefix(contentType, t[:len(t)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// fileType returns the MIME type of an uploaded file, going by its
+// contents when the browser didn't say.
+func fileType(fh *multipart.FileHeader, f multipart.File) (string, error) {
+	t, _, err := mime.ParseMediaType(fh.Header.Get("Content-Type"))
+	if err == nil && t != "application/octet-stream" {
+		return t, nil
+	}
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	t, _, _ = mime.ParseMediaType(http.DetectContentType(buf[:n]))
+	return t, nil
+}
+
+// errFileType is returned by saveUpload for files of types the form
+// doesn't take.
+var errFileType = errors.New("file type not allowed")
+
+func saveUpload(form Form, eid string, fh *multipart.FileHeader) (Attachment, error) {
+	a := Attachment{
+		Key:  fmt.Sprintf("%s/%s/%s", form.ID, eid, genID()),
+		Name: fh.Filename,
+	}
+
+	f, err := fh.Open()
+	if err != nil {
+		return a, err
+	}
+	defer f.Close()
+
+	if a.Type, err = fileType(fh, f); err != nil {
+		return a, err
+	}
+	if !typeAllowed(form, a.Type) {
+		return a, errFileType
+	}
+	if a.Size, err = f.Seek(0, io.SeekEnd); err != nil {
+		return a, err
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return a, err
+	}
+	return a, blobs.Put(a.Key, f, a.Size, a.Type)
+}
+
+// saveUploads stores the files posted for a new entry, returning their
+// attachments by field. Files are rejected with FieldErrors if their type
+// isn't allowed, and nothing is kept if any of them can't be saved.
+func saveUploads(form Form, eid string, files map[string][]*multipart.FileHeader) (map[string][]Attachment, error) {
+	attachments := make(map[string][]Attachment)
+	errs := make(FieldErrors)
+
+	for field, fhs := range files {
+		for _, fh := range fhs {
+			a, err := saveUpload(form, eid, fh)
+			if err == errFileType {
+				errs[field] = "must be one of " + form.UploadTypes
+				break
+			}
+			if err != nil {
+				deleteAttachments(form.ID, eid, attachments)
+				return nil, err
+			}
+			attachments[field] = append(attachments[field], a)
+		}
+	}
+
+	if len(errs) > 0 {
+		deleteAttachments(form.ID, eid, attachments)
+		return nil, errs
+	}
+	return attachments, nil
+}
+
+// belongsTo reports whether the attachment was uploaded with the entry.
+// Keys are checked as the values they're in could have been imported or
+// edited.
+func (a Attachment) belongsTo(id, eid string) bool {
+	return strings.HasPrefix(a.Key, id+"/"+eid+"/")
+}
+
+// deleteAttachments deletes the entry's attachments, skipping any that
+// aren't its own.
+func deleteAttachments(id, eid string, attachments map[string][]Attachment) {
+	for _, as := range attachments {
+		for _, a := range as {
+			if !a.belongsTo(id, eid) {
+				continue
+			}
+			if err := blobs.Delete(a.Key); err != nil {
+				log.Printf("Error deleting attachment %s: %s", a.Key, err.Error())
+			}
+		}
+	}
+}
+
+// removeEntry deletes the entry along with its attachments.
+func removeEntry(id string, entry Entry) error {
+	if err := store.DeleteEntry(id, entry.ID); err != nil {
+		return err
+	}
+	for _, value := range entry.Values {
+		for _, a := range entryAttachments(value) {
+			if !a.belongsTo(id, entry.ID) {
+				continue
+			}
+			if err := blobs.Delete(a.Key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func downloadAttachment(c web.C, w http.ResponseWriter, req *http.Request) {
+	form := c.Env["form"].(Form)
+	entry := c.Env["entry"].(Entry)
+
+	// Fields can have several files, picked by their position in the list.
+	var a *Attachment
+	items := parseList(entry.Values[c.URLParams["field"]])
+	if i, err := strconv.Atoi(req.URL.Query().Get("i")); err == nil && i >= 0 && i < len(items) {
+		a = parseAttachment(items[i])
+	} else if req.URL.Query().Get("i") == "" && len(items) > 0 {
+		a = parseAttachment(items[0])
+	}
+	if a == nil || !a.belongsTo(form.ID, entry.ID) {
+		http.Error(w, "File doesn't exist", http.StatusNotFound)
+		return
+	}
+
+	f, err := blobs.Get(a.Key)
+	if err == ErrNotFound {
+		http.Error(w, "File doesn't exist", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error getting file: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", a.Type)
+	w.Header().Set("Content-Length", strconv.FormatInt(a.Size, 10))
+	w.Header().Set("Content-Disposition",
+		mime.FormatMediaType("attachment", map[string]string{"filename": a.Name}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	io.Copy(w, f)
+}
+
+// maxFormSize caps submissions that aren't uploading files.
+const maxFormSize = 1 << 20
+
+var errUploadTooLarge = errors.New("upload too large")
+
+// parseSubmission parses url-encoded, JSON and multipart posts to a form,
+// returning the files posted if the form takes uploads.
+func parseSubmission(w http.ResponseWriter, req *http.Request, form Form) (map[string][]*multipart.FileHeader, error) {
+	t, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	switch t {
+	case "multipart/form-data":
+	case "application/json":
+		return nil, parseJSONSubmission(w, req)
+	default:
+		return nil, req.ParseForm()
+	}
+
+	limit := int64(maxFormSize)
+	if form.MaxUploadMB > 0 {
+		limit += int64(form.MaxUploadMB) << 20
+	}
+	req.Body = http.MaxBytesReader(w, req.Body, limit)
+	if err := req.ParseMultipartForm(maxFormSize); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, errUploadTooLarge
+		}
+		return nil, err
+	}
+
+	if form.MaxUploadMB == 0 {
+		return nil, nil
+	}
+	files := make(map[string][]*multipart.FileHeader)
+	for name, fhs := range req.MultipartForm.File {
+		field, _ := fieldName(name)
+		for _, fh := range fhs {
+			// Browsers post empty file inputs with no file name.
+			if fh.Filename != "" {
+				files[field] = append(files[field], fh)
+			}
+		}
+	}
+	return files, nil
+}
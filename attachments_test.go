@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/zenazn/goji/web"
+)
+
+// newTestBlobs starts a local blob store in a temporary directory, holding
+// the blobs named by keys.
+func newTestBlobs(t *testing.T, keys ...string) func() {
+	dir, err := ioutil.TempDir("", "formic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blobs, err = newLocalBlobStore(dir); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range keys {
+		if err := blobs.Put(k, strings.NewReader(k), int64(len(k)), "text/plain"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return func() { os.RemoveAll(dir) }
+}
+
+func blobExists(key string) bool {
+	f, err := blobs.Get(key)
+	if err != nil {
+		return false
+	}
+	f.Close()
+	return true
+}
+
+func TestRemoveEntry(t *testing.T) {
+	store = newMemoryStore()
+	defer newTestBlobs(t, "form/e/1", "form/e/2", "other/x/1")()
+
+	// Values can be edited or imported, so they can name another form's
+	// files.
+	entry := Entry{"e", 1000, map[string]string{
+		"cv":     Attachment{Key: "form/e/1"}.value(),
+		"photos": listValue([]string{Attachment{Key: "form/e/2"}.value(), "text"}, true),
+		"forged": Attachment{Key: "other/x/1"}.value(),
+	}}
+	addTestEntries(t, "form", entry)
+
+	if err := removeEntry("form", entry); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Entry("form", "e"); err != ErrNotFound {
+		t.Errorf("Entry after removing: %v; want ErrNotFound", err)
+	}
+	for key, want := range map[string]bool{"form/e/1": false, "form/e/2": false, "other/x/1": true} {
+		if blobExists(key) != want {
+			t.Errorf("%s exists: %v; want %v", key, !want, want)
+		}
+	}
+}
+
+func TestDownloadAttachment(t *testing.T) {
+	store = newMemoryStore()
+	defer newTestBlobs(t, "form/e/1", "other/x/1")()
+
+	form := Form{ID: "form"}
+	entry := Entry{"e", 1000, map[string]string{
+		"cv":     Attachment{Key: "form/e/1", Name: "cv.txt", Type: "text/plain", Size: 8}.value(),
+		"forged": Attachment{Key: "other/x/1", Name: "x.txt", Type: "text/plain", Size: 9}.value(),
+		"empty":  listValue(nil, true),
+		"text":   "hello",
+	}}
+
+	download := func(field, query string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "/dashboard/form/entries/e/files/"+field+"?"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		c := web.C{
+			URLParams: map[string]string{"field": field},
+			Env:       map[string]interface{}{"form": form, "entry": entry},
+		}
+		downloadAttachment(c, w, req)
+		return w
+	}
+
+	if w := download("cv", ""); w.Code != http.StatusOK || w.Body.String() != "form/e/1" {
+		t.Errorf("cv got %d %q", w.Code, w.Body.String())
+	}
+	for _, field := range []string{"forged", "empty", "text", "missing"} {
+		if w := download(field, ""); w.Code != http.StatusNotFound {
+			t.Errorf("%s got %d; want 404", field, w.Code)
+		}
+	}
+	if w := download("cv", "i=1"); w.Code != http.StatusNotFound {
+		t.Errorf("cv?i=1 got %d; want 404", w.Code)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"net/mail"
+	"net/url"
+	"strings"
+)
+
+const (
+	defaultAutoreplySubject = "Thanks for your entry to {{.Form}}"
+	defaultAutoreplyText    = `Thanks! We got your entry to {{.Form}} and will get back to you soon.
+`
+	// maxAddressLength is the longest address SMTP allows.
+	maxAddressLength = 254
+)
+
+// autoreplyTemplates returns the form's templates for autoreplies, falling
+// back to the defaults.
+func autoreplyTemplates(form Form) emailTemplates {
+	t := emailTemplates{"Autoreply", form.AutoreplySubject, form.AutoreplyText, form.AutoreplyHTML}
+	if t.Subject == "" {
+		t.Subject = defaultAutoreplySubject
+	}
+	if t.Text == "" {
+		t.Text = defaultAutoreplyText
+	}
+	return t
+}
+
+// autoreplyAddress returns the address in the entry's autoreply field. Only
+// a single bare address is accepted, so submitters can't add names or
+// other recipients to the email.
+func autoreplyAddress(form Form, entry Entry) string {
+	if form.AutoreplyField == "" {
+		return ""
+	}
+	items := parseList(entry.Values[form.AutoreplyField])
+	if len(items) != 1 {
+		return ""
+	}
+	v := strings.TrimSpace(items[0])
+	if len(v) > maxAddressLength {
+		return ""
+	}
+	addr, err := mail.ParseAddress(v)
+	if err != nil || addr.Address != v || !strings.Contains(v[strings.LastIndex(v, "@"):], ".") {
+		return ""
+	}
+	return addr.Address
+}
+
+func parseAutoreply(form *Form, values url.Values) error {
+	form.AutoreplyField, _ = fieldName(strings.TrimSpace(values.Get("autoreplyField")))
+	form.AutoreplySubject = strings.TrimSpace(values.Get("autoreplySubject"))
+	form.AutoreplyText = templateValue(values, "autoreplyText")
+	form.AutoreplyHTML = templateValue(values, "autoreplyHTML")
+	if form.AutoreplyField == "" && (form.AutoreplySubject != "" || form.AutoreplyText != "" || form.AutoreplyHTML != "") {
+		return errors.New("Autoreplies need the field with the address to send them to")
+	}
+	return checkTemplates(*form, autoreplyTemplates(*form))
+}
+
+// autoreplyEntry confirms a new entry to whoever submitted it, if the form
+// sends autoreplies and the entry has a valid address.
+func autoreplyEntry(form Form, entry Entry, base url.URL) error {
+	to := autoreplyAddress(form, entry)
+	if to == "" {
+		return nil
+	}
+	subject, text, html, err := newEntryEmail(form, entry, base).render(autoreplyTemplates(form))
+	if err != nil {
+		return err
+	}
+	return notify(Notification{
+		From:    formSender(form),
+		To:      []string{to},
+		Subject: subject,
+		Text:    text,
+		HTML:    html,
+	})
+}
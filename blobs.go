@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BlobStore holds uploaded files. Keys are slash separated paths made of
+// form, entry and file IDs.
+type BlobStore interface {
+	Put(key string, r io.Reader, size int64, contentType string) error
+	// Get returns ErrNotFound if there's no blob at key.
+	Get(key string) (io.ReadCloser, error)
+	Delete(key string) error
+	// DeletePrefix deletes every blob whose key starts with prefix.
+	DeletePrefix(prefix string) error
+}
+
+func newBlobStore(backend string) (BlobStore, error) {
+	switch backend {
+	case "local":
+		return newLocalBlobStore(*blobPath)
+	case "s3":
+		return newS3BlobStore(*s3Endpoint, *s3Region, *s3Bucket, *s3AccessKey, *s3SecretKey)
+	}
+	return nil, fmt.Errorf("unknown blob store %q", backend)
+}
+
+// localBlobStore keeps blobs as files under a directory.
+type localBlobStore struct {
+	root string
+}
+
+func newLocalBlobStore(root string) (*localBlobStore, error) {
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return nil, err
+	}
+	return &localBlobStore{root: root}, nil
+}
+
+func (s *localBlobStore) path(key string) (string, error) {
+	if key == "" || strings.Contains(key, "..") || strings.HasPrefix(key, "/") {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return filepath.Join(s.root, filepath.FromSlash(key)), nil
+}
+
+func (s *localBlobStore) Put(key string, r io.Reader, size int64, contentType string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so readers never see partial blobs.
+	f, err := os.OpenFile(path+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (s *localBlobStore) Get(key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (s *localBlobStore) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(path); os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *localBlobStore) DeletePrefix(prefix string) error {
+	path, err := s.path(strings.TrimSuffix(prefix, "/"))
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(path)
+}
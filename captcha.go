@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// CaptchaVerifier checks the token a CAPTCHA widget added to a submission.
+type CaptchaVerifier interface {
+	Verify(secret, token, remoteIP string) (bool, error)
+}
+
+// captchaProvider is a CAPTCHA service forms can require.
+type captchaProvider struct {
+	Name string
+	// Field is where the provider's widget puts its token.
+	Field string
+	// Script is the widget to load on pages with the form.
+	Script string
+	// Class is the class of the element the widget is shown in.
+	Class     string
+	verifyURL *string
+}
+
+var captchaProviders = []captchaProvider{
+	{
+		Name:      "recaptcha",
+		Field:     "g-recaptcha-response",
+		Script:    "https://www.google.com/recaptcha/api.js",
+		Class:     "g-recaptcha",
+		verifyURL: recaptchaVerifyURL,
+	},
+	{
+		Name:      "hcaptcha",
+		Field:     "h-captcha-response",
+		Script:    "https://js.hcaptcha.com/1/api.js",
+		Class:     "h-captcha",
+		verifyURL: hcaptchaVerifyURL,
+	},
+	{
+		Name:      "turnstile",
+		Field:     "cf-turnstile-response",
+		Script:    "https://challenges.cloudflare.com/turnstile/v0/api.js",
+		Class:     "cf-turnstile",
+		verifyURL: turnstileVerifyURL,
+	},
+}
+
+func findCaptchaProvider(name string) (captchaProvider, bool) {
+	for _, p := range captchaProviders {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return captchaProvider{}, false
+}
+
+// Verifier returns the verifier for the provider.
+func (p captchaProvider) Verifier() CaptchaVerifier {
+	return siteverify{url: *p.verifyURL, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// siteverify implements the verification API all supported providers
+// share: the secret and token are posted to the verify URL, which answers
+// with JSON saying whether the token is valid.
+type siteverify struct {
+	url    string
+	client *http.Client
+}
+
+func (v siteverify) Verify(secret, token, remoteIP string) (bool, error) {
+	if token == "" {
+		return false, nil
+	}
+	form := url.Values{
+		"secret":   {secret},
+		"response": {token},
+	}
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
+	}
+	resp, err := v.client.PostForm(v.url, form)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("CAPTCHA verification returned %s", resp.Status)
+	}
+
+	var result struct {
+		Success bool `json:"success"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, err
+	}
+	return result.Success, nil
+}
+
+func parseCaptcha(form *Form, values url.Values) error {
+	provider := strings.TrimSpace(values.Get("captchaProvider"))
+	if provider == "" {
+		form.CaptchaProvider, form.CaptchaSiteKey, form.CaptchaSecret = "", "", ""
+		return nil
+	}
+	if _, ok := findCaptchaProvider(provider); !ok {
+		return fmt.Errorf("Unknown CAPTCHA provider %q", provider)
+	}
+	form.CaptchaProvider = provider
+	form.CaptchaSiteKey = strings.TrimSpace(values.Get("captchaSiteKey"))
+	// Secrets aren't shown on the form page, so a blank one keeps the
+	// current secret.
+	if secret := strings.TrimSpace(values.Get("captchaSecret")); secret != "" {
+		form.CaptchaSecret = secret
+	}
+	if form.CaptchaSecret == "" {
+		return errors.New("A secret key is needed to verify CAPTCHAs")
+	}
+	return nil
+}
+
+// verifyCaptcha checks the CAPTCHA a submission to the form needs, if any,
+// and removes the tokens widgets add from values. hCaptcha also posts its
+// token as g-recaptcha-response.
+func verifyCaptcha(form Form, values url.Values, remoteIP string) (bool, error) {
+	provider, ok := findCaptchaProvider(form.CaptchaProvider)
+	if !ok {
+		return true, nil
+	}
+	token := values.Get(provider.Field)
+	for _, p := range captchaProviders {
+		delete(values, p.Field)
+	}
+	return provider.Verifier().Verify(form.CaptchaSecret, token, remoteIP)
+}
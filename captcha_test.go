@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// newSiteverify starts a verify endpoint that accepts the token "pass" for
+// the secret "secret", pointing every provider at it.
+func newSiteverify(t *testing.T) (requests *[]url.Values, cleanup func()) {
+	requests = new([]url.Values)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if err := req.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		*requests = append(*requests, req.PostForm)
+		if req.PostForm.Get("response") == "down" {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		success := req.PostForm.Get("secret") == "secret" && req.PostForm.Get("response") == "pass"
+		fmt.Fprintf(w, `{"success": %v}`, success)
+	}))
+
+	urls := []*string{recaptchaVerifyURL, hcaptchaVerifyURL, turnstileVerifyURL}
+	saved := make([]string, len(urls))
+	for i, u := range urls {
+		saved[i], *u = *u, server.URL
+	}
+	return requests, func() {
+		server.Close()
+		for i, u := range urls {
+			*u = saved[i]
+		}
+	}
+}
+
+func TestVerifyCaptcha(t *testing.T) {
+	requests, cleanup := newSiteverify(t)
+	defer cleanup()
+
+	tests := []struct {
+		provider string
+		values   url.Values
+		solved   bool
+		err      bool
+	}{
+		{"", url.Values{"name": {"Mark"}}, true, false},
+		{"recaptcha", url.Values{"g-recaptcha-response": {"pass"}}, true, false},
+		{"recaptcha", url.Values{"g-recaptcha-response": {"fail"}}, false, false},
+		{"hcaptcha", url.Values{"h-captcha-response": {"pass"}, "g-recaptcha-response": {"pass"}}, true, false},
+		{"turnstile", url.Values{"g-recaptcha-response": {"pass"}}, false, false},
+		{"turnstile", url.Values{"cf-turnstile-response": {"down"}}, false, true},
+	}
+	for _, test := range tests {
+		form := Form{CaptchaProvider: test.provider, CaptchaSecret: "secret"}
+		solved, err := verifyCaptcha(form, test.values, "192.0.2.1")
+		if solved != test.solved || (err != nil) != test.err {
+			t.Errorf("%s %v = %v, %v; want %v", test.provider, test.values, solved, err, test.solved)
+		}
+		for _, p := range captchaProviders {
+			if _, ok := test.values[p.Field]; ok {
+				t.Errorf("%s %v: %s wasn't removed", test.provider, test.values, p.Field)
+			}
+		}
+	}
+
+	// Missing tokens aren't sent to the provider.
+	if len(*requests) != 4 {
+		t.Errorf("got %d verify requests; want 4", len(*requests))
+	}
+	if ip := (*requests)[0].Get("remoteip"); ip != "192.0.2.1" {
+		t.Errorf("remoteip = %q; want 192.0.2.1", ip)
+	}
+}
+
+func TestSubmitEntryCaptcha(t *testing.T) {
+	_, cleanup := newSiteverify(t)
+	defer cleanup()
+	form := newTestForm(t, Form{CaptchaProvider: "recaptcha", CaptchaSecret: "secret"})
+
+	if code, _ := submit(t, form, url.Values{"name": {"Mark"}}); code != http.StatusBadRequest {
+		t.Errorf("submission without a CAPTCHA got %d; want 400", code)
+	}
+	code, result := submit(t, form, url.Values{"name": {"Mark"}, "g-recaptcha-response": {"pass"}})
+	if code != http.StatusCreated {
+		t.Fatalf("got %d %+v; want 201", code, result)
+	}
+	entry, err := store.Entry(form.ID, result.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := entry.Values["g-recaptcha-response"]; ok {
+		t.Error("CAPTCHA token was stored with the entry")
+	}
+}
+
+func TestParseCaptcha(t *testing.T) {
+	form := Form{CaptchaSecret: "old"}
+	if err := parseCaptcha(&form, url.Values{"captchaProvider": {"hcaptcha"}, "captchaSiteKey": {"site"}}); err != nil {
+		t.Fatal(err)
+	}
+	if form.CaptchaProvider != "hcaptcha" || form.CaptchaSiteKey != "site" || form.CaptchaSecret != "old" {
+		t.Errorf("got %+v; want the secret kept", form)
+	}
+	if err := parseCaptcha(&form, url.Values{"captchaProvider": {"other"}}); err == nil {
+		t.Error("unknown provider was accepted")
+	}
+	if err := parseCaptcha(&Form{}, url.Values{"captchaProvider": {"turnstile"}}); err == nil {
+		t.Error("provider without a secret was accepted")
+	}
+	if err := parseCaptcha(&form, url.Values{}); err != nil || form.CaptchaProvider != "" || form.CaptchaSecret != "" {
+		t.Errorf("got %+v, %v; want CAPTCHAs turned off", form, err)
+	}
+}
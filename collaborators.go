@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strings"
+
+	"github.com/gorilla/sessions"
+	"github.com/zenazn/goji/web"
+)
+
+const (
+	RoleOwner  = "owner"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+var roleRanks = map[string]int{
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleOwner:  3,
+}
+
+// hasRole reports whether role grants at least what required does.
+func hasRole(role, required string) bool {
+	return roleRanks[role] > 0 && roleRanks[role] >= roleRanks[required]
+}
+
+func inviteCollaborator(c web.C, w http.ResponseWriter, req *http.Request) {
+	var (
+		collaborator Collaborator
+		err          error
+	)
+
+	session := c.Env["session"].(*sessions.Session)
+	form := c.Env["form"].(Form)
+
+	defer func() {
+		if err != nil {
+			session.AddFlash(err.Error(), "warning")
+		}
+		session.Save(req, w)
+		url := fmt.Sprintf("/dashboard/%s", form.ID)
+		http.Redirect(w, req, url, http.StatusFound)
+	}()
+
+	if err = req.ParseForm(); err != nil {
+		return
+	}
+
+	address, err := mail.ParseAddress(req.PostForm.Get("email"))
+	if err != nil {
+		err = errors.New("Invalid email address")
+		return
+	}
+	collaborator.Email = strings.ToLower(address.Address)
+	if collaborator.Email == c.Env["email"] {
+		err = errors.New("You can't invite yourself")
+		return
+	}
+
+	collaborator.Role = req.PostForm.Get("role")
+	if collaborator.Role != RoleEditor && collaborator.Role != RoleViewer {
+		err = errors.New("Collaborators can only be editors or viewers")
+		return
+	}
+
+	if err = store.SetCollaborator(form.ID, collaborator); err != nil {
+		return
+	}
+
+	dashboardURL := createURL(req)
+	dashboardURL.Path = fmt.Sprintf("/dashboard/%s", form.ID)
+	subject := fmt.Sprintf("[Formic] %s shared %s with you", c.Env["email"], form.Name)
+	body := fmt.Sprintf(`%s shared the form "%s" with you as %s.
+
+Log in to Formic as %s to see it:
+%s
+`, c.Env["email"], form.Name, collaborator.Role, collaborator.Email, dashboardURL.String())
+	if err := notify(Notification{To: []string{collaborator.Email}, Subject: subject, Text: body}); err != nil {
+		session.AddFlash("Collaborator added but the invitation email couldn't be sent: "+err.Error(), "warning")
+		return
+	}
+
+	session.AddFlash("Invitation sent to "+collaborator.Email, "success")
+}
+
+func removeCollaborator(c web.C, w http.ResponseWriter, req *http.Request) {
+	session := c.Env["session"].(*sessions.Session)
+	form := c.Env["form"].(Form)
+
+	err := store.RemoveCollaborator(form.ID, c.URLParams["email"])
+	if err != nil {
+		session.AddFlash(err.Error(), "warning")
+	} else {
+		session.AddFlash("Collaborator removed", "success")
+	}
+	session.Save(req, w)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// Commands are run as `formic <command> [flags]` instead of serving.
+
+func runCommand(args []string) error {
+	switch args[0] {
+	case "repair":
+		return repairCommand(args[1:])
+	case "import":
+		return importCommand(args[1:])
+	}
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+// Repairer is implemented by stores that can be left with partly written
+// forms or entries.
+type Repairer interface {
+	// Repair finds and, unless dryRun is set, fixes inconsistencies,
+	// returning a description of each one.
+	Repair(dryRun bool) ([]string, error)
+}
+
+func repairCommand(args []string) error {
+	fs := flag.NewFlagSet("repair", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "only report what would be repaired")
+	fs.Parse(args)
+
+	repairer, ok := store.(Repairer)
+	if !ok {
+		fmt.Printf("The %s store doesn't need repairs\n", *storeType)
+		return nil
+	}
+
+	problems, err := repairer.Repair(*dryRun)
+	if err != nil {
+		return err
+	}
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	if *dryRun {
+		fmt.Printf("%d problems found\n", len(problems))
+	} else {
+		fmt.Printf("%d problems repaired\n", len(problems))
+	}
+	return nil
+}
+
+func importCommand(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	id := fs.String("form", "", "ID of the form to import entries into")
+	timeColumn := fs.String("time-column", "Submitted", "column holding submission times")
+	dryRun := fs.Bool("dry-run", false, "only report rows that would fail")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: formic import -form <id> [flags] <file.csv>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *id == "" || fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	form, err := store.Form(*id)
+	if err == ErrNotFound {
+		return fmt.Errorf("form %s doesn't exist", *id)
+	}
+	if err != nil {
+		return err
+	}
+	if form.Deleted != 0 {
+		return errors.New("form is in the trash")
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	report, err := importEntries(form.ID, f, *timeColumn, *dryRun)
+	if err != nil {
+		return err
+	}
+	for _, failure := range report.Failures {
+		fmt.Println(failure)
+	}
+	if *dryRun {
+		fmt.Printf("%d entries would be imported, %d rows would fail\n",
+			report.Imported, len(report.Failures))
+	} else {
+		fmt.Printf("%d entries imported, %d rows failed\n",
+			report.Imported, len(report.Failures))
+	}
+	return nil
+}
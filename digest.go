@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	NotifyHourly = "hourly"
+	NotifyDaily  = "daily"
+	NotifyWeekly = "weekly"
+
+	// maxDigestEntries is how many entries digests list. The rest are only
+	// counted.
+	maxDigestEntries = 100
+	// maxDigestValue is how many characters of each value digests show.
+	maxDigestValue = 40
+)
+
+var digestPeriods = map[string]time.Duration{
+	NotifyHourly: time.Hour,
+	NotifyDaily:  24 * time.Hour,
+	NotifyWeekly: 7 * 24 * time.Hour,
+}
+
+// parseNotifyMode reads whether the form's recipient gets an email for each
+// entry or digests of them. Digests start from when they're turned on.
+func parseNotifyMode(form *Form, values url.Values) error {
+	mode := values.Get("notifyMode")
+	if _, ok := digestPeriods[mode]; mode != "" && !ok {
+		return errors.New("Notifications must be immediate, hourly, daily or weekly")
+	}
+	if mode != "" && form.NotifyMode == "" {
+		form.DigestedUntil = time.Now().UTC().Unix()
+	}
+	form.NotifyMode = mode
+	return nil
+}
+
+// digestDue reports whether the form's next digest should be sent.
+func digestDue(form Form, now time.Time) bool {
+	period, ok := digestPeriods[form.NotifyMode]
+	return ok && !time.Unix(form.DigestedUntil, 0).Add(period).After(now)
+}
+
+type digestRow struct {
+	ID        string
+	Submitted string
+	Values    []string
+}
+
+// digest is what digest emails are made from.
+type digest struct {
+	Form  string
+	Count int
+	Since string
+	// URL links to the form on the dashboard if base-url is set.
+	URL    string
+	Fields []string
+	Rows   []digestRow
+	// More is how many entries weren't listed.
+	More int
+}
+
+func newDigest(form Form, entries []Entry, since time.Time) digest {
+	d := digest{
+		Form:  form.Name,
+		Count: len(entries),
+		Since: since.UTC().Format("Jan 2, 2006 15:04 MST"),
+	}
+	if *baseURL != "" {
+		d.URL = strings.TrimRight(*baseURL, "/") + "/dashboard/" + form.ID
+	}
+	if len(entries) > maxDigestEntries {
+		d.More = len(entries) - maxDigestEntries
+		entries = entries[:maxDigestEntries]
+	}
+
+	known := make(map[string]bool)
+	var fields []string
+	for _, entry := range entries {
+		for field := range entry.Values {
+			if !known[field] {
+				known[field] = true
+				fields = append(fields, field)
+			}
+		}
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		d.Fields = append(d.Fields, fieldLabel(field))
+	}
+
+	for _, entry := range entries {
+		row := digestRow{ID: entry.ID, Submitted: formatTime(entry.Submitted)}
+		for _, field := range fields {
+			row.Values = append(row.Values, digestValue(entry.Values[field]))
+		}
+		d.Rows = append(d.Rows, row)
+	}
+	return d
+}
+
+// digestValue fits a value on one short line.
+func digestValue(value string) string {
+	v := strings.Join(strings.Fields(displayValue(value)), " ")
+	if r := []rune(v); len(r) > maxDigestValue {
+		v = string(r[:maxDigestValue-1]) + "…"
+	}
+	return v
+}
+
+func (d digest) Subject() string {
+	if d.Count == 1 {
+		return fmt.Sprintf("[Formic] 1 new entry for %s", d.Form)
+	}
+	return fmt.Sprintf("[Formic] %d new entries for %s", d.Count, d.Form)
+}
+
+// Text lays the entries out in a plain text table.
+func (d digest) Text() string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s\n---\n\n%d new since %s:\n\n", d.Form, d.Count, d.Since)
+	tw := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Submitted\tID\t%s\n", strings.Join(d.Fields, "\t"))
+	for _, row := range d.Rows {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", row.Submitted, row.ID, strings.Join(row.Values, "\t"))
+	}
+	tw.Flush()
+	if d.More > 0 {
+		fmt.Fprintf(&b, "\nand %d more.\n", d.More)
+	}
+	if d.URL != "" {
+		fmt.Fprintf(&b, "\n%s\n", d.URL)
+	}
+	return b.String()
+}
+
+var digestHTML = htmltemplate.Must(htmltemplate.New("digest").Parse(`<p><strong>{{.Count}}</strong> new since {{.Since}}:</p>
+<table cellpadding="4" border="1" style="border-collapse: collapse">
+  <tr><th>Submitted</th><th>ID</th>{{range .Fields}}<th>{{.}}</th>{{end}}</tr>
+  {{range .Rows}}<tr><td>{{.Submitted}}</td><td>{{.ID}}</td>{{range .Values}}<td>{{.}}</td>{{end}}</tr>
+  {{end}}
+</table>
+{{if .More}}<p>and {{.More}} more.</p>{{end}}
+{{if .URL}}<p><a href="{{.URL}}">See them all</a></p>{{end}}
+`))
+
+func (d digest) HTML() (string, error) {
+	var b bytes.Buffer
+	err := digestHTML.Execute(&b, d)
+	return b.String(), err
+}
+
+// sendDigest emails the form's recipient the entries since its last
+// digest.
+func sendDigest(form Form, now time.Time) error {
+	// Entries submitted this second might still be coming in.
+	until := now.Unix() - 1
+	entries, err := store.Entries(form.ID, EntryQuery{
+		Since:  form.DigestedUntil + 1,
+		Until:  until,
+		Oldest: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(entries) > 0 {
+		d := newDigest(form, entries, time.Unix(form.DigestedUntil, 0))
+		html, err := d.HTML()
+		if err != nil {
+			return err
+		}
+		err = notify(Notification{
+			From:    formSender(form),
+			To:      []string{form.EmailRecepient},
+			Subject: d.Subject(),
+			Text:    d.Text(),
+			HTML:    html,
+		})
+		if err != nil {
+			return err
+		}
+		log.Printf("Sent digest of %d entries of form %s", len(entries), form.ID)
+	}
+
+	// Reload the form so changes made in the meantime aren't lost and only
+	// move on if nothing else sent this digest.
+	latest, err := store.Form(form.ID)
+	if err != nil {
+		return err
+	}
+	if latest.NotifyMode != form.NotifyMode ||
+		latest.DigestedUntil != form.DigestedUntil {
+		return nil
+	}
+	latest.DigestedUntil = until
+	return store.SaveForm(latest)
+}
+
+// sendDigests sends every digest that's due.
+func sendDigests() error {
+	ids, err := store.Forms()
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	for _, id := range ids {
+		form, err := store.Form(id)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if form.Deleted != 0 || form.EmailRecepient == "" || !digestDue(form, now) {
+			continue
+		}
+		if err = sendDigest(form, now); err != nil {
+			log.Printf("Error sending digest of form %s: %s", id, err.Error())
+		}
+	}
+	return nil
+}
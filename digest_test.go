@@ -0,0 +1,159 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testNotifier keeps the notifications it's asked to send.
+type testNotifier struct {
+	sent []Notification
+}
+
+func (n *testNotifier) Notify(notification Notification) error {
+	n.sent = append(n.sent, notification)
+	return nil
+}
+
+func newTestNotifier() (*testNotifier, func()) {
+	saved := notifier
+	n := &testNotifier{}
+	notifier = n
+	return n, func() { notifier = saved }
+}
+
+func TestParseNotifyMode(t *testing.T) {
+	var form Form
+	if err := parseNotifyMode(&form, url.Values{"notifyMode": {"monthly"}}); err == nil {
+		t.Error("unknown mode was accepted")
+	}
+	before := time.Now().Unix()
+	if err := parseNotifyMode(&form, url.Values{"notifyMode": {NotifyDaily}}); err != nil {
+		t.Fatal(err)
+	}
+	if form.NotifyMode != NotifyDaily || form.DigestedUntil < before {
+		t.Errorf("got %+v; want daily digests from now", form)
+	}
+
+	// Switching between digests keeps the cursor.
+	form.DigestedUntil = 1000
+	if err := parseNotifyMode(&form, url.Values{"notifyMode": {NotifyWeekly}}); err != nil || form.DigestedUntil != 1000 {
+		t.Errorf("got %+v, %v; want the cursor kept", form, err)
+	}
+}
+
+func TestDigestDue(t *testing.T) {
+	now := time.Unix(100000, 0)
+	tests := []struct {
+		form Form
+		want bool
+	}{
+		{Form{}, false},
+		{Form{NotifyMode: NotifyHourly, DigestedUntil: 100000 - 3600}, true},
+		{Form{NotifyMode: NotifyHourly, DigestedUntil: 100000 - 3599}, false},
+		{Form{NotifyMode: NotifyDaily, DigestedUntil: 100000 - 3600}, false},
+	}
+	for _, test := range tests {
+		if got := digestDue(test.form, now); got != test.want {
+			t.Errorf("digestDue(%+v) = %v; want %v", test.form, got, test.want)
+		}
+	}
+}
+
+func TestSendDigest(t *testing.T) {
+	n, cleanup := newTestNotifier()
+	defer cleanup()
+	form := newTestForm(t, Form{EmailRecepient: "mark@example.com", NotifyMode: NotifyDaily, DigestedUntil: 1000})
+	addTestEntries(t, form.ID,
+		Entry{"old", 1000, map[string]string{"name": "Old"}},
+		Entry{"a", 1001, map[string]string{"name": "Steve", "message": strings.Repeat("long ", 20)}},
+		Entry{"b", 2000, map[string]string{"name": "Mark", "tags": listValue([]string{"x", "y"}, true)}},
+		Entry{"late", 3000, map[string]string{"name": "Late"}},
+	)
+
+	if err := sendDigest(form, time.Unix(3000, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if len(n.sent) != 1 {
+		t.Fatalf("sent %d emails; want 1", len(n.sent))
+	}
+	sent := n.sent[0]
+	if sent.Subject != "[Formic] 2 new entries for Contact" || sent.To[0] != "mark@example.com" {
+		t.Errorf("sent %q to %v", sent.Subject, sent.To)
+	}
+	for _, s := range []string{"Steve", "Mark", "x, y", "long long", "…"} {
+		if !strings.Contains(sent.Text, s) || !strings.Contains(sent.HTML, s) {
+			t.Errorf("digest doesn't have %q:\n%s", s, sent.Text)
+		}
+	}
+	for _, s := range []string{"Old", "Late"} {
+		if strings.Contains(sent.Text, s) {
+			t.Errorf("digest has %q:\n%s", s, sent.Text)
+		}
+	}
+
+	stored, err := store.Form(form.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.DigestedUntil != 2999 {
+		t.Errorf("cursor = %d; want 2999", stored.DigestedUntil)
+	}
+
+	// The late entry is in the next digest, and without new entries only
+	// the cursor moves on.
+	if err := sendDigest(stored, time.Unix(3001, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ = store.Form(form.ID); len(n.sent) != 2 || stored.DigestedUntil != 3000 {
+		t.Errorf("sent %d emails and cursor = %d; want the late entry sent and 3000", len(n.sent), stored.DigestedUntil)
+	}
+	if err := sendDigest(stored, time.Unix(3002, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ = store.Form(form.ID); len(n.sent) != 2 || stored.DigestedUntil != 3001 {
+		t.Errorf("sent %d emails and cursor = %d; want none and 3001", len(n.sent), stored.DigestedUntil)
+	}
+}
+
+func TestSendDigestChanged(t *testing.T) {
+	n, cleanup := newTestNotifier()
+	defer cleanup()
+	form := newTestForm(t, Form{EmailRecepient: "mark@example.com", NotifyMode: NotifyDaily, DigestedUntil: 1000})
+
+	// Another process already sent this digest.
+	sent := form
+	sent.DigestedUntil = 1500
+	if err := store.SaveForm(sent); err != nil {
+		t.Fatal(err)
+	}
+	if err := sendDigest(form, time.Unix(2000, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := store.Form(form.ID); stored.DigestedUntil != 1500 || len(n.sent) != 0 {
+		t.Errorf("cursor = %d; want it left at 1500", stored.DigestedUntil)
+	}
+}
+
+func TestUpdateFormDigestCursor(t *testing.T) {
+	form := newTestForm(t, Form{EmailRecepient: "mark@example.com", NotifyMode: NotifyDaily, DigestedUntil: 1000})
+
+	// The cursor moved on after the settings page was loaded.
+	moved := form
+	moved.DigestedUntil = 2000
+	if err := store.SaveForm(moved); err != nil {
+		t.Fatal(err)
+	}
+	got := updateTestForm(t, form, url.Values{"emailRecepient": {"mark@example.com"}, "notifyMode": {NotifyWeekly}})
+	if got.NotifyMode != NotifyWeekly || got.DigestedUntil != 2000 {
+		t.Errorf("got %s from %d; want weekly from 2000", got.NotifyMode, got.DigestedUntil)
+	}
+
+	got = updateTestForm(t, got, url.Values{"emailRecepient": {"mark@example.com"}})
+	got = updateTestForm(t, got, url.Values{"emailRecepient": {"mark@example.com"}, "notifyMode": {NotifyDaily}})
+	if got.DigestedUntil <= 2000 {
+		t.Errorf("cursor = %d; want digests turned back on to start over", got.DigestedUntil)
+	}
+}
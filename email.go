@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/zenazn/goji/web"
+)
+
+const (
+	defaultEmailSubject = "[Formic] New entry for {{.Form}}"
+	defaultEmailText    = `{{.Form}}
+---
+
+{{range .Fields}}{{.Label}}: {{.Value}}
+{{end}}`
+)
+
+// entryEmail is what notification templates are executed with.
+type entryEmail struct {
+	Form      string
+	ID        string
+	Submitted time.Time
+	// URL links to the entry on the dashboard.
+	URL    string
+	Fields []emailField
+	// Values maps field names to their values, e.g. {{.Values.email}}.
+	Values map[string]string
+	// ReplyTo is the address in the form's reply-to field, if any.
+	ReplyTo string
+}
+
+type emailField struct {
+	Name  string
+	Label string
+	Value string
+}
+
+func newEntryEmail(form Form, entry Entry, base url.URL) entryEmail {
+	base.Path = fmt.Sprintf("/dashboard/%s/entries/%s", form.ID, entry.ID)
+	email := entryEmail{
+		Form:      form.Name,
+		ID:        entry.ID,
+		Submitted: time.Unix(entry.Submitted, 0).UTC(),
+		URL:       base.String(),
+		Values:    make(map[string]string, len(entry.Values)),
+		ReplyTo:   entryReplyTo(form, entry),
+	}
+	for field, value := range entry.Values {
+		email.Values[field] = displayValue(value)
+		email.Fields = append(email.Fields, emailField{field, fieldLabel(field), email.Values[field]})
+	}
+	sort.Sort(emailFields(email.Fields))
+	return email
+}
+
+type emailFields []emailField
+
+func (f emailFields) Len() int           { return len(f) }
+func (f emailFields) Less(i, j int) bool { return f[i].Name < f[j].Name }
+func (f emailFields) Swap(i, j int)      { f[i], f[j] = f[j], f[i] }
+
+// emailTemplates are what an email is made from. The HTML body is only
+// rendered if there's a template for it, and values are escaped in it.
+type emailTemplates struct {
+	// Label names the email in errors.
+	Label   string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// notificationTemplates returns the form's templates for entry emails,
+// falling back to the defaults.
+func notificationTemplates(form Form) emailTemplates {
+	t := emailTemplates{"Email", form.EmailSubject, form.EmailText, form.EmailHTML}
+	if t.Subject == "" {
+		t.Subject = defaultEmailSubject
+	}
+	if t.Text == "" {
+		t.Text = defaultEmailText
+	}
+	return t
+}
+
+func (e entryEmail) render(t emailTemplates) (subject, text, html string, err error) {
+	var b bytes.Buffer
+	st, err := template.New("subject").Parse(t.Subject)
+	if err == nil {
+		err = st.Execute(&b, e)
+	}
+	if err != nil {
+		return "", "", "", fmt.Errorf("%s subject: %s", t.Label, err.Error())
+	}
+	// Subjects are a single header line.
+	subject = strings.Join(strings.Fields(b.String()), " ")
+
+	b.Reset()
+	tt, err := template.New("text").Parse(t.Text)
+	if err == nil {
+		err = tt.Execute(&b, e)
+	}
+	if err != nil {
+		return "", "", "", fmt.Errorf("%s text: %s", t.Label, err.Error())
+	}
+	text = b.String()
+
+	if t.HTML == "" {
+		return subject, text, "", nil
+	}
+	b.Reset()
+	ht, err := htmltemplate.New("html").Parse(t.HTML)
+	if err == nil {
+		err = ht.Execute(&b, e)
+	}
+	if err != nil {
+		return "", "", "", fmt.Errorf("%s HTML: %s", t.Label, err.Error())
+	}
+	return subject, text, b.String(), nil
+}
+
+// checkTemplates tries templates out on a made up entry, since they can
+// parse and still fail on one, e.g. by naming a field entries don't have.
+func checkTemplates(form Form, t emailTemplates) error {
+	sample := entryEmail{
+		Form:   form.Name,
+		ID:     "0dbdfe78",
+		Fields: []emailField{{"email", "Email", "you@example.com"}},
+		Values: map[string]string{"email": "you@example.com"},
+	}
+	_, _, _, err := sample.render(t)
+	return err
+}
+
+// templateValue reads a posted template, which browsers send with CRLF line
+// endings. Blank templates are empty.
+func templateValue(values url.Values, name string) string {
+	v := values.Get(name)
+	if strings.TrimSpace(v) == "" {
+		return ""
+	}
+	return strings.Replace(v, "\r\n", "\n", -1)
+}
+
+// sampleEntry is shown in previews: the form's latest entry, or made up
+// values if it has none.
+func sampleEntry(form Form) (Entry, error) {
+	entries, err := store.Entries(form.ID, EntryQuery{Limit: 1})
+	if err != nil {
+		return Entry{}, err
+	}
+	if len(entries) > 0 {
+		return entries[0], nil
+	}
+
+	entry := Entry{ID: "0dbdfe78", Submitted: time.Now().Unix(), Values: map[string]string{}}
+	fields, err := store.Fields(form.ID)
+	if err != nil {
+		return entry, err
+	}
+	for _, field := range fields {
+		entry.Values[field] = "Example " + fieldLabel(field)
+	}
+	if len(fields) == 0 {
+		entry.Values["message"] = "Hello!"
+	}
+	for _, field := range []string{"email", form.ReplyToField, form.AutoreplyField} {
+		if field != "" {
+			entry.Values[field] = "you@example.com"
+		}
+	}
+	return entry, nil
+}
+
+func parseEmailTemplates(form *Form, values url.Values) error {
+	form.EmailSubject = strings.TrimSpace(values.Get("emailSubject"))
+	form.EmailText = templateValue(values, "emailText")
+	form.EmailHTML = templateValue(values, "emailHTML")
+	return checkTemplates(*form, notificationTemplates(*form))
+}
+
+// notifyEntry emails the form's recipient about a new entry.
+func notifyEntry(form Form, entry Entry, base url.URL) error {
+	email := newEntryEmail(form, entry, base)
+	subject, text, html, err := email.render(notificationTemplates(form))
+	if err != nil {
+		return err
+	}
+	return notify(Notification{
+		From:    formSender(form),
+		To:      []string{form.EmailRecepient},
+		ReplyTo: email.ReplyTo,
+		Subject: subject,
+		Text:    text,
+		HTML:    html,
+	})
+}
+
+// defaultSender is the notify-from address.
+func defaultSender() mail.Address {
+	from, err := mail.ParseAddress(*notifyFrom)
+	if err != nil {
+		return mail.Address{}
+	}
+	return *from
+}
+
+// formSender returns the From address of the form's emails, taking what
+// the form doesn't set from notify-from.
+func formSender(form Form) string {
+	from := defaultSender()
+	if form.EmailFromName != "" {
+		from.Name = form.EmailFromName
+	}
+	if form.EmailFromAddress != "" {
+		from.Address = form.EmailFromAddress
+	}
+	return from.String()
+}
+
+// entryReplyTo returns the address in the entry's reply-to field, if it
+// has a valid one.
+func entryReplyTo(form Form, entry Entry) string {
+	if form.ReplyToField == "" {
+		return ""
+	}
+	items := parseList(entry.Values[form.ReplyToField])
+	if len(items) == 0 {
+		return ""
+	}
+	addr, err := mail.ParseAddress(strings.TrimSpace(items[0]))
+	if err != nil {
+		return ""
+	}
+	return addr.String()
+}
+
+// parseEmailRecepient returns the address notifications are sent to, which
+// is blank if they aren't sent.
+func parseEmailRecepient(values url.Values) (string, error) {
+	recepient := strings.TrimSpace(values.Get("emailRecepient"))
+	if recepient == "" {
+		return "", nil
+	}
+	addr, err := mail.ParseAddress(recepient)
+	if err != nil {
+		return "", fmt.Errorf("%q isn't an email address", recepient)
+	}
+	return addr.String(), nil
+}
+
+func parseEmailSender(form *Form, values url.Values) error {
+	form.EmailFromName = strings.TrimSpace(values.Get("emailFromName"))
+	if strings.ContainsAny(form.EmailFromName, "\r\n") {
+		return errors.New("Sender name can't have line breaks")
+	}
+	form.EmailFromAddress = strings.TrimSpace(values.Get("emailFromAddress"))
+	if form.EmailFromAddress != "" {
+		addr, err := mail.ParseAddress(form.EmailFromAddress)
+		if err != nil || addr.Name != "" {
+			return fmt.Errorf("%q isn't an email address", form.EmailFromAddress)
+		}
+		form.EmailFromAddress = addr.Address
+	}
+	form.ReplyToField, _ = fieldName(strings.TrimSpace(values.Get("replyToField")))
+	return nil
+}
+
+// previewEmail shows the email the posted settings make for the form's
+// latest entry, or the autoreply to it if asked for.
+func previewEmail(c web.C, w http.ResponseWriter, req *http.Request) {
+	form := c.Env["form"].(Form)
+
+	if err := req.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	autoreply := req.URL.Query().Get("autoreply") != ""
+	data := map[string]interface{}{
+		"Role":      c.Env["role"],
+		"Autoreply": autoreply,
+	}
+	err := parseEmailTemplates(&form, req.PostForm)
+	if err == nil {
+		err = parseEmailSender(&form, req.PostForm)
+	}
+	if err == nil {
+		err = parseAutoreply(&form, req.PostForm)
+	}
+	data["Form"] = form
+	if err != nil {
+		data["Error"] = err.Error()
+		r.HTML(w, http.StatusOK, "email", data)
+		return
+	}
+
+	entry, err := sampleEntry(form)
+	if err != nil {
+		http.Error(w, "Error previewing email: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	email := newEntryEmail(form, entry, createURL(req))
+	t := notificationTemplates(form)
+	data["To"], data["ReplyTo"] = form.EmailRecepient, email.ReplyTo
+	if autoreply {
+		t = autoreplyTemplates(form)
+		data["To"], data["ReplyTo"] = autoreplyAddress(form, entry), ""
+	}
+	data["From"] = formSender(form)
+	data["Subject"], data["Text"], data["HTML"], err = email.render(t)
+	if err != nil {
+		data["Error"] = err.Error()
+	}
+	r.HTML(w, http.StatusOK, "email", data)
+}
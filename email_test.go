@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestParseEmailRecepient(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+		err   bool
+	}{
+		{"", "", false},
+		{" mark@example.com ", "<mark@example.com>", false},
+		{"Mark <mark@example.com>", `"Mark" <mark@example.com>`, false},
+		{"mark@example.com\r\nBcc: spam@example.com", "", true},
+		{"mark@example.com, steve@example.com", "", true},
+		{"mark", "", true},
+	}
+	for _, test := range tests {
+		got, err := parseEmailRecepient(url.Values{"emailRecepient": {test.value}})
+		if got != test.want || (err != nil) != test.err {
+			t.Errorf("parseEmailRecepient(%q) = %q, %v; want %q", test.value, got, err, test.want)
+		}
+	}
+}
+
+func TestParseEmailSender(t *testing.T) {
+	for _, values := range []url.Values{
+		{"emailFromName": {"Formic\r\nBcc: spam@example.com"}},
+		{"emailFromAddress": {"forms@example.com\r\nBcc: spam@example.com"}},
+		{"emailFromAddress": {"Forms <forms@example.com>"}},
+	} {
+		if err := parseEmailSender(&Form{}, values); err == nil {
+			t.Errorf("%q was accepted", values)
+		}
+	}
+
+	var form Form
+	if err := parseEmailSender(&form, url.Values{"emailFromName": {"Forms"}, "emailFromAddress": {" forms@example.com "}}); err != nil {
+		t.Fatal(err)
+	}
+	if from := formSender(form); from != `"Forms" <forms@example.com>` {
+		t.Errorf("formSender = %q", from)
+	}
+}
+
+func TestUpdateFormRecepient(t *testing.T) {
+	form := newTestForm(t, Form{EmailRecepient: "mark@example.com"})
+
+	got := updateTestForm(t, form, url.Values{"emailRecepient": {"mark@example.com\r\nBcc: spam@example.com"}})
+	if got.EmailRecepient != "mark@example.com" {
+		t.Errorf("recepient = %q; want it unchanged", got.EmailRecepient)
+	}
+	got = updateTestForm(t, form, url.Values{"emailRecepient": {"steve@example.com"}})
+	if got.EmailRecepient != "<steve@example.com>" {
+		t.Errorf("recepient = %q; want <steve@example.com>", got.EmailRecepient)
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/zenazn/goji/web"
+)
+
+const (
+	entriesPerPage = 50
+	dateLayout     = "2006-01-02"
+	filterPrefix   = "filter."
+)
+
+// queryError is a malformed entries listing query.
+type queryError string
+
+func (e queryError) Error() string { return string(e) }
+
+// entryFilters reads the date range and field filters of an entries listing
+// from the URL query. Dates are whole days in UTC, both inclusive.
+func entryFilters(query url.Values) (EntryQuery, error) {
+	var q EntryQuery
+
+	if v := query.Get("since"); v != "" {
+		t, err := time.Parse(dateLayout, v)
+		if err != nil {
+			return q, queryError("Invalid since date")
+		}
+		q.Since = t.Unix()
+	}
+
+	if v := query.Get("until"); v != "" {
+		t, err := time.Parse(dateLayout, v)
+		if err != nil {
+			return q, queryError("Invalid until date")
+		}
+		q.Until = t.AddDate(0, 0, 1).Unix() - 1
+	}
+
+	for field, value := range filterValues(query) {
+		if value == "" {
+			continue
+		}
+		if q.Filters == nil {
+			q.Filters = make(map[string]string)
+		}
+		q.Filters[field] = value
+	}
+
+	return q, nil
+}
+
+type entriesPage struct {
+	Entries []Entry
+	// Newer and Older are the queries of the adjacent pages, or "" if
+	// there are none.
+	Newer string
+	Older string
+}
+
+// pageEntries returns the page of the form's entries, or spam, selected by
+// the after or before cursor in the URL query, newest first.
+func pageEntries(list func(id string, q EntryQuery) ([]Entry, error), id string, query url.Values) (entriesPage, error) {
+	var page entriesPage
+
+	q, err := entryFilters(query)
+	if err != nil {
+		return page, err
+	}
+	q.Limit = entriesPerPage + 1
+
+	before := query.Get("before")
+	after := query.Get("after")
+	switch {
+	case before != "":
+		if q.After, err = parseEntryCursor(before); err != nil {
+			return page, queryError("Invalid page")
+		}
+		q.Oldest = true
+	case after != "":
+		if q.After, err = parseEntryCursor(after); err != nil {
+			return page, queryError("Invalid page")
+		}
+	}
+
+	entries, err := list(id, q)
+	if err != nil {
+		return page, err
+	}
+	more := len(entries) > entriesPerPage
+	if more {
+		entries = entries[:entriesPerPage]
+	}
+	if q.Oldest {
+		for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+			entries[i], entries[j] = entries[j], entries[i]
+		}
+	}
+	page.Entries = entries
+	if len(entries) == 0 {
+		return page, nil
+	}
+
+	link := func(param string, cursor EntryCursor) string {
+		v := url.Values{}
+		for name, values := range query {
+			if name != "after" && name != "before" {
+				v[name] = values
+			}
+		}
+		v.Set(param, cursor.String())
+		return "?" + v.Encode()
+	}
+	if (q.Oldest && more) || (!q.Oldest && after != "") {
+		page.Newer = link("before", entries[0].Cursor())
+	}
+	if q.Oldest || more {
+		page.Older = link("after", entries[len(entries)-1].Cursor())
+	}
+
+	return page, nil
+}
+
+// filterValues returns the field filters in the URL query by field name.
+func filterValues(query url.Values) map[string]string {
+	filters := make(map[string]string)
+	for name := range query {
+		if strings.HasPrefix(name, filterPrefix) {
+			filters[strings.TrimPrefix(name, filterPrefix)] = query.Get(name)
+		}
+	}
+	return filters
+}
+
+// requireEntry goes inside requireForm and puts the entry with the ID in
+// the URL in c.Env["entry"].
+func requireEntry(h web.HandlerFunc) web.HandlerFunc {
+	return func(c web.C, w http.ResponseWriter, req *http.Request) {
+		form := c.Env["form"].(Form)
+
+		entry, err := store.Entry(form.ID, c.URLParams["eid"])
+		if err == ErrNotFound {
+			http.Error(w, "Entry doesn't exist", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Error getting entry: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		c.Env["entry"] = entry
+		h(c, w, req)
+	}
+}
+
+// entryFields returns the form's fields along with any others the entry
+// has, sorted.
+func entryFields(id string, entry Entry) ([]string, error) {
+	fields, err := store.Fields(id)
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		known[field] = true
+	}
+	for field := range entry.Values {
+		if !known[field] {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	return fields, nil
+}
+
+func showEntry(c web.C, w http.ResponseWriter, req *http.Request) {
+	form := c.Env["form"].(Form)
+	entry := c.Env["entry"].(Entry)
+
+	fields, err := entryFields(form.ID, entry)
+	if err != nil {
+		http.Error(w, "Error showing entry: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	r.HTML(w, http.StatusOK, "entry", map[string]interface{}{
+		"Form":     form,
+		"Role":     c.Env["role"],
+		"Entry":    entry,
+		"Fields":   fields,
+		"Messages": getMessages(c, w, req),
+	})
+}
+
+func updateEntry(c web.C, w http.ResponseWriter, req *http.Request) {
+	var err error
+
+	session := c.Env["session"].(*sessions.Session)
+	form := c.Env["form"].(Form)
+	entry := c.Env["entry"].(Entry)
+
+	defer func() {
+		if err != nil {
+			session.AddFlash("Error updating entry: "+err.Error(), "warning")
+		} else {
+			session.AddFlash("Entry updated", "success")
+		}
+		session.Save(req, w)
+		url := fmt.Sprintf("/dashboard/%s/entries/%s", form.ID, entry.ID)
+		http.Redirect(w, req, url, http.StatusFound)
+	}()
+
+	if err = req.ParseForm(); err != nil {
+		return
+	}
+
+	fields, err := entryFields(form.ID, entry)
+	if err != nil {
+		return
+	}
+
+	// Only fields already on the form can be edited, and ones left out of
+	// the request or holding attachments keep their values. Lists are
+	// edited one value per line, and emptying one removes it.
+	values := make(map[string]string, len(fields))
+	edited := url.Values{}
+	for _, field := range fields {
+		old, ok := entry.Values[field]
+		if len(entryAttachments(old)) > 0 {
+			values[field] = old
+		} else if v, posted := req.PostForm[field]; posted && isList(old) {
+			edited[field] = splitLines(v[0])
+			if len(edited[field]) > 0 {
+				values[field] = listValue(edited[field], true)
+			}
+		} else if posted {
+			edited[field] = v[:1]
+			values[field] = v[0]
+		} else if ok {
+			values[field] = old
+		}
+	}
+	if errs := checkReserved(nil, edited); errs != nil {
+		err = errs
+		return
+	}
+
+	schema, err := store.Schema(form.ID)
+	if err != nil {
+		return
+	}
+	if len(schema) > 0 {
+		// Fields that were dropped from the schema are left alone.
+		checked := url.Values{}
+		for _, spec := range schema {
+			checked[spec.Name] = parseList(values[spec.Name])
+		}
+		if errs := validateEntry(schema, checked); errs != nil {
+			err = errs
+			return
+		}
+	}
+	entry.Values = values
+
+	err = store.UpdateEntry(form.ID, entry)
+}
+
+func deleteEntry(c web.C, w http.ResponseWriter, req *http.Request) {
+	session := c.Env["session"].(*sessions.Session)
+	form := c.Env["form"].(Form)
+	entry := c.Env["entry"].(Entry)
+
+	err := removeEntry(form.ID, entry)
+	if err != nil {
+		session.AddFlash(err.Error(), "warning")
+	} else {
+		session.AddFlash("Entry deleted", "success")
+	}
+	session.Save(req, w)
+}
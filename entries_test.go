@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zenazn/goji/web"
+)
+
+func TestPageEntries(t *testing.T) {
+	store = newMemoryStore()
+
+	// Entries share submission times so pages have to break ties by ID.
+	n := 2*entriesPerPage + 10
+	for i := 0; i < n; i++ {
+		entry := Entry{
+			ID:        fmt.Sprintf("%08x", i),
+			Submitted: int64(1000 + i/3),
+			Values:    map[string]string{"n": fmt.Sprint(i)},
+		}
+		if err := store.AddEntry("form", entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	page := func(query string) entriesPage {
+		values, err := url.ParseQuery(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := pageEntries(store.Entries, "form", values)
+		if err != nil {
+			t.Fatalf("pageEntries(%q): %s", query, err.Error())
+		}
+		return p
+	}
+
+	// Going older, every entry comes up once, newest first.
+	var pages []entriesPage
+	next := ""
+	for i := 0; ; i++ {
+		p := page(next)
+		pages = append(pages, p)
+		if p.Older == "" {
+			break
+		}
+		if i > n {
+			t.Fatal("paging never ends")
+		}
+		next = p.Older[1:]
+	}
+	if len(pages) != 3 {
+		t.Fatalf("got %d pages; want 3", len(pages))
+	}
+	if pages[0].Newer != "" {
+		t.Errorf("first page links to newer entries: %q", pages[0].Newer)
+	}
+	seen := 0
+	for _, p := range pages {
+		for _, entry := range p.Entries {
+			if want := fmt.Sprintf("%08x", n-1-seen); entry.ID != want {
+				t.Fatalf("entry %d is %s; want %s", seen, entry.ID, want)
+			}
+			seen++
+		}
+	}
+	if seen != n {
+		t.Errorf("saw %d entries; want %d", seen, n)
+	}
+
+	// Going back newer gives the same pages.
+	for i := len(pages) - 1; i > 0; i-- {
+		if pages[i].Newer == "" {
+			t.Fatalf("page %d has no newer link", i)
+		}
+		p := page(pages[i].Newer[1:])
+		if len(p.Entries) != len(pages[i-1].Entries) ||
+			p.Entries[0].ID != pages[i-1].Entries[0].ID {
+			t.Errorf("newer than page %d isn't page %d", i, i-1)
+		}
+	}
+	if p := page(pages[1].Newer[1:]); p.Newer != "" {
+		t.Errorf("first page reached going newer links to newer entries: %q", p.Newer)
+	}
+
+	if _, err := pageEntries(store.Entries, "form", url.Values{"after": {"nope"}}); err == nil {
+		t.Error("invalid cursor accepted")
+	}
+}
+
+func TestPageEntriesFilters(t *testing.T) {
+	store = newMemoryStore()
+	for i, name := range []string{"Mark", "Steve", "Marsha"} {
+		entry := Entry{ID: fmt.Sprint(i), Submitted: int64(1000 + i), Values: map[string]string{"name": name}}
+		if err := store.AddEntry("form", entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p, err := pageEntries(store.Entries, "form", url.Values{filterPrefix + "name": {"mar"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Entries) != 2 || p.Entries[0].ID != "2" || p.Entries[1].ID != "0" {
+		t.Errorf("entries = %v; want Marsha and Mark", p.Entries)
+	}
+}
+
+func TestUpdateEntry(t *testing.T) {
+	store = newMemoryStore()
+	form := Form{ID: "form"}
+	entry := Entry{"e", 1000, map[string]string{
+		"name": "Mark",
+		"tags": listValue([]string{"a", "b"}, true),
+		"file": Attachment{Key: "form/e/1", Name: "cv.pdf"}.value(),
+		"city": "Manila",
+	}}
+	addTestEntries(t, form.ID, entry)
+
+	update := func(values url.Values) Entry {
+		req, err := http.NewRequest("POST", "/dashboard/form/entries/e", strings.NewReader(values.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		c := web.C{Env: map[string]interface{}{"session": testSession(req), "form": form, "entry": entry}}
+		updateEntry(c, httptest.NewRecorder(), req)
+		entry, err = store.Entry(form.ID, "e")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return entry
+	}
+
+	got := update(url.Values{
+		"name": {"Steve"},
+		"tags": {"c\r\n\r\nd\n"},
+		"file": {"replaced"},
+		"new":  {"ignored"},
+	})
+	want := map[string]string{
+		"name": "Steve",
+		"tags": listValue([]string{"c", "d"}, true),
+		"file": entry.Values["file"],
+		"city": "Manila",
+	}
+	if !reflect.DeepEqual(got.Values, want) {
+		t.Errorf("values = %v; want %v", got.Values, want)
+	}
+
+	// Emptied lists are removed rather than stored empty.
+	got = update(url.Values{"tags": {" \n"}})
+	if _, ok := got.Values["tags"]; ok {
+		t.Errorf("tags = %q; want it removed", got.Values["tags"])
+	}
+
+	got = update(url.Values{"city": {attachmentPrefix + `{"Key":"other/e/1"}`}})
+	if got.Values["city"] != "Manila" {
+		t.Errorf("city = %q; want reserved values rejected", got.Values["city"])
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/zenazn/goji/web"
+)
+
+// exportBatch is how many entries are read from the store at a time while
+// exporting.
+const exportBatch = 500
+
+// exportedEntry is how entries are exported as JSON, with bracketed fields
+// nested and lists as arrays.
+type exportedEntry struct {
+	ID        string                 `json:"id"`
+	Submitted string                 `json:"submitted"`
+	Values    map[string]interface{} `json:"values"`
+}
+
+func newExportedEntry(entry Entry) exportedEntry {
+	return exportedEntry{
+		ID:        entry.ID,
+		Submitted: time.Unix(entry.Submitted, 0).UTC().Format(time.RFC3339),
+		Values:    nestValues(entry.Values),
+	}
+}
+
+// entryWriter writes entries in one of the export formats. Flush is
+// called after each batch.
+type entryWriter interface {
+	Begin() error
+	Write(entry Entry) error
+	Flush() error
+	End() error
+}
+
+type csvEntryWriter struct {
+	w      *csv.Writer
+	fields []string
+}
+
+func (cw *csvEntryWriter) Begin() error {
+	return cw.w.Write(append([]string{"Submitted"}, cw.fields...))
+}
+
+func (cw *csvEntryWriter) Write(entry Entry) error {
+	record := make([]string, len(cw.fields)+1)
+	record[0] = newExportedEntry(entry).Submitted
+	for i, field := range cw.fields {
+		record[i+1] = displayValue(entry.Values[field])
+	}
+	return cw.w.Write(record)
+}
+
+func (cw *csvEntryWriter) Flush() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+func (cw *csvEntryWriter) End() error {
+	return cw.Flush()
+}
+
+// jsonEntryWriter writes a JSON array of entries, or one entry per line if
+// lines is set.
+type jsonEntryWriter struct {
+	w       io.Writer
+	lines   bool
+	written bool
+}
+
+func (jw *jsonEntryWriter) Begin() error {
+	if jw.lines {
+		return nil
+	}
+	_, err := io.WriteString(jw.w, "[")
+	return err
+}
+
+func (jw *jsonEntryWriter) Write(entry Entry) error {
+	data, err := json.Marshal(newExportedEntry(entry))
+	if err != nil {
+		return err
+	}
+	sep := ""
+	if jw.lines {
+		data = append(data, '\n')
+	} else if jw.written {
+		sep = ","
+	}
+	jw.written = true
+	_, err = io.WriteString(jw.w, sep+string(data))
+	return err
+}
+
+func (jw *jsonEntryWriter) Flush() error {
+	return nil
+}
+
+func (jw *jsonEntryWriter) End() error {
+	if jw.lines {
+		return nil
+	}
+	_, err := io.WriteString(jw.w, "]\n")
+	return err
+}
+
+var exportFormats = []string{"csv", "json", "ndjson"}
+
+type exportLink struct {
+	Format string
+	URL    string
+}
+
+// exportLinks returns links exporting the entries selected by the filters
+// in the URL query in each format.
+func exportLinks(id string, query url.Values) []exportLink {
+	v := url.Values{}
+	for name, values := range query {
+		if name != "after" && name != "before" {
+			v[name] = values
+		}
+	}
+	var links []exportLink
+	for _, format := range exportFormats {
+		v.Set("format", format)
+		links = append(links, exportLink{
+			Format: format,
+			URL:    fmt.Sprintf("/dashboard/%s/export?%s", id, v.Encode()),
+		})
+	}
+	return links
+}
+
+func exportEntries(c web.C, w http.ResponseWriter, req *http.Request) {
+	form := c.Env["form"].(Form)
+
+	q, err := entryFilters(req.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q.Oldest = true
+	q.Limit = exportBatch
+
+	fields, err := store.Fields(form.ID)
+	if err != nil {
+		http.Error(w, "Error exporting entries: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sort.Strings(fields)
+
+	var (
+		ew          entryWriter
+		contentType string
+	)
+	format := req.URL.Query().Get("format")
+	switch format {
+	case "", "csv":
+		format = "csv"
+		ew = &csvEntryWriter{w: csv.NewWriter(w), fields: fields}
+		contentType = "text/csv; charset=utf-8"
+	case "json":
+		ew = &jsonEntryWriter{w: w}
+		contentType = "application/json"
+	case "ndjson":
+		ew = &jsonEntryWriter{w: w, lines: true}
+		contentType = "application/x-ndjson"
+	default:
+		http.Error(w, "Unknown export format", http.StatusBadRequest)
+		return
+	}
+
+	// Read the first batch before anything is written so errors can still
+	// get a proper response.
+	entries, err := store.Entries(form.ID, q)
+	if err != nil {
+		http.Error(w, "Error exporting entries: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="%s-entries.%s"`, form.ID, format))
+
+	if err = ew.Begin(); err != nil {
+		return
+	}
+	for len(entries) > 0 {
+		for _, entry := range entries {
+			if err = ew.Write(entry); err != nil {
+				return
+			}
+		}
+		if err = ew.Flush(); err != nil {
+			return
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		if len(entries) < q.Limit {
+			break
+		}
+
+		q.After = entries[len(entries)-1].Cursor()
+		entries, err = store.Entries(form.ID, q)
+		if err != nil {
+			// Too late to change the response, so the export is cut short.
+			log.Printf("Error exporting entries of form %s: %s", form.ID, err.Error())
+			return
+		}
+	}
+	ew.End()
+}
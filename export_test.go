@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zenazn/goji/web"
+)
+
+func export(t *testing.T, form Form, query string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("GET", "/dashboard/"+form.ID+"/export?"+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	exportEntries(web.C{Env: map[string]interface{}{"form": form}}, w, req)
+	return w
+}
+
+func TestExportEntries(t *testing.T) {
+	store = newMemoryStore()
+	form := Form{ID: "form"}
+	addTestEntries(t, form.ID,
+		Entry{"b", 1000, map[string]string{"name": "Mark", "tags": listValue([]string{"a", "b"}, true)}},
+		Entry{"a", 0, map[string]string{"name": "Steve", "address.city": "Manila"}},
+	)
+
+	w := export(t, form, "")
+	if ct := w.Header().Get("Content-Type"); ct != "text/csv; charset=utf-8" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"Submitted", "address.city", "name", "tags"},
+		{"1970-01-01T00:00:00Z", "Manila", "Steve", ""},
+		{"1970-01-01T00:16:40Z", "", "Mark", "a, b"},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("CSV = %v; want %v", records, want)
+	}
+
+	w = export(t, form, "format=json&filter.name=mark")
+	var exported []exportedEntry
+	if err := json.Unmarshal(w.Body.Bytes(), &exported); err != nil {
+		t.Fatalf("%q: %s", w.Body.String(), err.Error())
+	}
+	wantJSON := []exportedEntry{{
+		ID:        "b",
+		Submitted: "1970-01-01T00:16:40Z",
+		Values:    map[string]interface{}{"name": "Mark", "tags": []interface{}{"a", "b"}},
+	}}
+	if !reflect.DeepEqual(exported, wantJSON) {
+		t.Errorf("JSON = %+v; want %+v", exported, wantJSON)
+	}
+
+	w = export(t, form, "format=ndjson")
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("NDJSON = %q; want 2 lines", w.Body.String())
+	}
+	var first exportedEntry
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil || first.ID != "a" {
+		t.Errorf("first line = %q, %v; want entry a", lines[0], err)
+	}
+
+	if w = export(t, form, "format=xml"); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown format got %d; want 400", w.Code)
+	}
+}
+
+// TestExportEntriesBatches exports more than a batch of entries submitted
+// in the same seconds, some before 1970.
+func TestExportEntriesBatches(t *testing.T) {
+	store = newMemoryStore()
+	form := Form{ID: "form"}
+	n := 2*exportBatch + 7
+	for i := 0; i < n; i++ {
+		addTestEntries(t, form.ID, Entry{
+			ID:        fmt.Sprintf("%04d", i),
+			Submitted: int64(i/10 - 50),
+			Values:    map[string]string{"n": fmt.Sprint(i)},
+		})
+	}
+
+	w := export(t, form, "format=ndjson")
+	s := bufio.NewScanner(w.Body)
+	i := 0
+	for ; s.Scan(); i++ {
+		var entry exportedEntry
+		if err := json.Unmarshal(s.Bytes(), &entry); err != nil {
+			t.Fatal(err)
+		}
+		if entry.Values["n"] != fmt.Sprint(i) {
+			t.Fatalf("line %d has entry %v", i, entry.Values["n"])
+		}
+	}
+	if i != n {
+		t.Errorf("exported %d entries; want %d", i, n)
+	}
+}
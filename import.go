@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/zenazn/goji/web"
+)
+
+// importTimeLayouts are the submission time formats accepted on import,
+// besides Unix timestamps. Times without a zone are taken as UTC.
+var importTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"01/02/2006 15:04:05",
+	"01/02/2006",
+}
+
+// maxImportSize caps uploaded CSV files.
+const maxImportSize = 32 << 20
+
+func parseImportTime(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n, nil
+	}
+	for _, layout := range importTimeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.Unix(), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid submission time %q", s)
+}
+
+type importReport struct {
+	Imported int
+	// Failures describes each row that couldn't be imported.
+	Failures []string
+}
+
+// importEntries adds an entry to the form for each row of the CSV read from
+// r. The first row names the fields, and timeColumn is the one holding
+// submission times. With dryRun set, rows are only checked.
+func importEntries(id string, r io.Reader, timeColumn string, dryRun bool) (importReport, error) {
+	var report importReport
+
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err == io.EOF {
+		return report, errors.New("The CSV file is empty")
+	}
+	if err != nil {
+		return report, err
+	}
+
+	// Columns are named like submitted fields, e.g. address[city] or tags[].
+	timeIndex := -1
+	fields := make([]string, len(header))
+	multi := make(map[string]bool)
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return report, fmt.Errorf("Column %d has no name", i+1)
+		}
+		if name == timeColumn {
+			timeIndex = i
+			continue
+		}
+		field, m := fieldName(name)
+		fields[i] = field
+		multi[field] = multi[field] || m
+	}
+	if timeIndex < 0 {
+		return report, fmt.Errorf("There's no %q column", timeColumn)
+	}
+
+	schema, err := store.Schema(id)
+	if err != nil {
+		return report, err
+	}
+
+	for row := 2; ; row++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if perr, ok := err.(*csv.ParseError); ok && perr.Err == csv.ErrFieldCount {
+			report.Failures = append(report.Failures, fmt.Sprintf(
+				"Row %d: expected %d columns, got %d", row, len(header), len(record)))
+			continue
+		}
+		if err != nil {
+			return report, err
+		}
+
+		submitted, err := parseImportTime(record[timeIndex])
+		if err != nil {
+			report.Failures = append(report.Failures,
+				fmt.Sprintf("Row %d: %s", row, err.Error()))
+			continue
+		}
+
+		entry := Entry{
+			ID:        genID(),
+			Submitted: submitted,
+			Values:    make(map[string]string),
+		}
+		values := url.Values{}
+		for i, value := range record {
+			if i != timeIndex {
+				values.Add(fields[i], value)
+			}
+		}
+		for field, vals := range values {
+			entry.Values[field] = listValue(vals, multi[field])
+		}
+		if errs := checkReserved(validateEntry(schema, values), values); errs != nil {
+			report.Failures = append(report.Failures,
+				fmt.Sprintf("Row %d: %s", row, errs.Error()))
+			continue
+		}
+
+		if !dryRun {
+			if err := store.AddEntry(id, entry); err != nil {
+				return report, err
+			}
+		}
+		report.Imported++
+	}
+
+	return report, nil
+}
+
+// maxImportFailures is how many failed rows are shown on the dashboard.
+const maxImportFailures = 10
+
+func uploadEntries(c web.C, w http.ResponseWriter, req *http.Request) {
+	var err error
+
+	session := c.Env["session"].(*sessions.Session)
+	form := c.Env["form"].(Form)
+
+	defer func() {
+		if err != nil {
+			session.AddFlash("Error importing entries: "+err.Error(), "warning")
+		}
+		session.Save(req, w)
+		url := fmt.Sprintf("/dashboard/%s", form.ID)
+		http.Redirect(w, req, url, http.StatusFound)
+	}()
+
+	req.Body = http.MaxBytesReader(w, req.Body, maxImportSize)
+	if err = req.ParseMultipartForm(maxImportSize); err != nil {
+		return
+	}
+	file, _, err := req.FormFile("file")
+	if err != nil {
+		err = errors.New("Choose a CSV file to import")
+		return
+	}
+	defer file.Close()
+
+	timeColumn := strings.TrimSpace(req.FormValue("timeColumn"))
+	if timeColumn == "" {
+		timeColumn = "Submitted"
+	}
+	dryRun := req.FormValue("dryRun") != ""
+
+	report, err := importEntries(form.ID, file, timeColumn, dryRun)
+	if err != nil {
+		return
+	}
+
+	for i, failure := range report.Failures {
+		if i == maxImportFailures {
+			session.AddFlash(fmt.Sprintf("...and %d more rows",
+				len(report.Failures)-maxImportFailures), "warning")
+			break
+		}
+		session.AddFlash(failure, "warning")
+	}
+	if dryRun {
+		session.AddFlash(fmt.Sprintf("%d entries would be imported, %d rows would fail",
+			report.Imported, len(report.Failures)), "info")
+	} else {
+		session.AddFlash(fmt.Sprintf("%d entries imported, %d rows failed",
+			report.Imported, len(report.Failures)), "success")
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestImportEntries(t *testing.T) {
+	store = newMemoryStore()
+	csv := `Submitted,name,tags[],tags[],address[city]
+2015-03-01,Mark,a,b,Manila
+1960-01-02 03:04,Old,,,
+1000,Forged,"formic-list:[""x""]",,
+nope,Bad time,,,
+2015-03-02,Short
+`
+	report, err := importEntries("form", strings.NewReader(csv), "Submitted", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Imported != 2 || len(report.Failures) != 3 {
+		t.Fatalf("report = %+v; want 2 imported and 3 failures", report)
+	}
+	for i, row := range []string{"Row 4:", "Row 5:", "Row 6:"} {
+		if !strings.HasPrefix(report.Failures[i], row) {
+			t.Errorf("failure %d = %q; want it about %s", i, report.Failures[i], row)
+		}
+	}
+
+	entries, err := store.Entries("form", EntryQuery{Oldest: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries; want 2", len(entries))
+	}
+	if entries[0].Submitted != -315521760 || entries[1].Submitted != 1425168000 {
+		t.Errorf("submitted = %d, %d", entries[0].Submitted, entries[1].Submitted)
+	}
+	want := map[string]string{
+		"name":         "Mark",
+		"tags":         listValue([]string{"a", "b"}, true),
+		"address.city": "Manila",
+	}
+	if !reflect.DeepEqual(entries[1].Values, want) {
+		t.Errorf("values = %v; want %v", entries[1].Values, want)
+	}
+}
+
+func TestImportEntriesSchema(t *testing.T) {
+	store = newMemoryStore()
+	if err := store.SaveSchema("form", []FieldSpec{{Name: "email", Type: FieldEmail, Required: true}}); err != nil {
+		t.Fatal(err)
+	}
+	csv := "Date,email\n2015-03-01,mark@example.com\n2015-03-01,nope\n"
+
+	report, err := importEntries("form", strings.NewReader(csv), "Date", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Imported != 1 || len(report.Failures) != 1 {
+		t.Errorf("report = %+v; want 1 imported and 1 failure", report)
+	}
+	if entries, _ := store.Entries("form", EntryQuery{}); len(entries) != 0 {
+		t.Errorf("dry run imported %d entries", len(entries))
+	}
+
+	if _, err := importEntries("form", strings.NewReader(csv), "Submitted", false); err == nil {
+		t.Error("missing time column accepted")
+	}
+	if _, err := importEntries("form", strings.NewReader(""), "Submitted", false); err == nil {
+		t.Error("empty file accepted")
+	}
+}
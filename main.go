@@ -2,11 +2,14 @@ package main
 
 import (
 	"errors"
+	"flag"
 	"fmt"
 	"html/template"
+	"log"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -15,7 +18,6 @@ import (
 	"github.com/dustin/randbo"
 	"github.com/garyburd/redigo/redis"
 	"github.com/gorilla/sessions"
-	"github.com/mailgun/mailgun-go"
 	"github.com/unrolled/render"
 	"github.com/zenazn/goji"
 	"github.com/zenazn/goji/web"
@@ -27,14 +29,54 @@ import (
 
 type Form struct {
 	ID             string
+	Owner          string
 	Name           string
 	RedirectURL    string
 	EmailRecepient string
+	Deleted        int64
+
+	EmailSubject string
+	EmailText    string
+	EmailHTML    string
+
+	NotifyMode    string
+	DigestedUntil int64
+
+	EmailFromName    string
+	EmailFromAddress string
+	ReplyToField     string
+
+	AutoreplyField   string
+	AutoreplySubject string
+	AutoreplyText    string
+	AutoreplyHTML    string
+
+	RetentionDays   int
+	RetentionAction string
+	RetentionFields string
+	AnonymizedUntil int64
+
+	MaxUploadMB int
+	UploadTypes string
+
+	AllowedOrigins string
+
+	HoneypotField    string
+	MinSubmitSeconds int
+
+	RateLimitIP   int
+	RateLimitForm int
+
+	CaptchaProvider string
+	CaptchaSiteKey  string
+	CaptchaSecret   string
+
+	PowDifficulty int
 }
 
-type EntryMeta struct {
-	ID        string
-	Submitted int64
+type FormAccess struct {
+	Form
+	Role string
 }
 
 type Message struct {
@@ -45,30 +87,86 @@ type Message struct {
 var (
 	r                   *render.Render
 	rp                  *redis.Pool
-	rs                  *redistore.RediStore
-	gun                 mailgun.Mailgun
+	rs                  sessions.Store
+	store               FormStore
+	blobs               BlobStore
+	limiter             RateLimiter = newMemoryRateLimiter()
+	notifier            Notifier
+	storeType           = config.String("store", "redis")
+	trashRetentionDays  = config.Int("trash-retention-days", 30)
 	redisHost           = config.String("redis-host", "localhost")
+	boltPath            = config.String("bolt-path", "formic.db")
+	postgresURL         = config.String("postgres-url", "")
+	blobStoreType       = config.String("blob-store", "local")
+	blobPath            = config.String("blob-path", "uploads")
+	s3Endpoint          = config.String("s3-endpoint", "https://s3.amazonaws.com")
+	s3Region            = config.String("s3-region", "us-east-1")
+	s3Bucket            = config.String("s3-bucket", "")
+	s3AccessKey         = config.String("s3-access-key", "")
+	s3SecretKey         = config.String("s3-secret-key", "")
+	rateLimitIP         = config.Int("rate-limit-ip", 0)
+	rateLimitForm       = config.Int("rate-limit-form", 0)
+	rateLimitWindow     = config.Int("rate-limit-window", 3600)
+	trustedProxies      = config.String("trusted-proxies", "")
+	recaptchaVerifyURL  = config.String("recaptcha-verify-url", "https://www.google.com/recaptcha/api/siteverify")
+	hcaptchaVerifyURL   = config.String("hcaptcha-verify-url", "https://api.hcaptcha.com/siteverify")
+	turnstileVerifyURL  = config.String("turnstile-verify-url", "https://challenges.cloudflare.com/turnstile/v0/siteverify")
 	sessionSecret       = config.String("session-secret", "")
 	googleClientID      = config.String("google-client-id", "")
 	googleClientSecret  = config.String("google-client-secret", "")
 	googleAllowedEmails = config.String("google-allowed-emails", "")
 	mailgunDomain       = config.String("mailgun-domain", "")
 	mailgunKey          = config.String("mailgun-key", "")
+	notifierType        = config.String("notifier", "")
+	notifyFrom          = config.String("notify-from", "Formic <formic@marksteve.com>")
+	smtpHost            = config.String("smtp-host", "")
+	smtpPort            = config.Int("smtp-port", 587)
+	smtpUsername        = config.String("smtp-username", "")
+	smtpPassword        = config.String("smtp-password", "")
+	sendmailPath        = config.String("sendmail-path", "/usr/sbin/sendmail")
+	baseURL             = config.String("base-url", "")
 )
 
 // Utils
 
-func key(args ...string) string {
-	args = append([]string{"formic"}, args...)
-	return strings.Join(args, ":")
-}
-
 func genID() string {
 	p := make([]byte, 4)
 	randbo.New().Read(p)
 	return fmt.Sprintf("%x", p)
 }
 
+// splitList splits a comma separated list, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func formatDate(t int64) string {
+	return time.Unix(t, 0).UTC().Format("Jan 2, 2006")
+}
+
+func formatTime(t int64) string {
+	return time.Unix(t, 0).UTC().Format(time.Stamp)
+}
+
+// every runs fn now and then every interval in the background, logging
+// any errors.
+func every(interval time.Duration, fn func() error) {
+	go func() {
+		for {
+			if err := fn(); err != nil {
+				log.Println(err.Error())
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
 func getMessages(c web.C, w http.ResponseWriter, req *http.Request) []Message {
 	session := c.Env["session"].(*sessions.Session)
 	var messages []Message
@@ -89,20 +187,8 @@ func getMessages(c web.C, w http.ResponseWriter, req *http.Request) []Message {
 	return messages
 }
 
-func getForm(rc redis.Conn, k string, form *Form) error {
-	v, err := redis.Values(
-		rc.Do("HGETALL", k),
-	)
-	if err != nil {
-		return err
-	}
-	redis.ScanStruct(v, form)
-	return nil
-}
-
 func createURL(req *http.Request) url.URL {
-	var url_ *url.URL
-	url_ = req.URL
+	url_ := *req.URL
 	url_.Scheme = "http"
 	fwdScheme := req.Header.Get("X-Forwarded-Proto")
 	if fwdScheme != "" {
@@ -111,7 +197,7 @@ func createURL(req *http.Request) url.URL {
 	url_.Host = req.Host
 	url_.RawQuery = ""
 	url_.Fragment = ""
-	return *url_
+	return url_
 }
 
 func loginGoogleConfig(req *http.Request) *oauth2.Config {
@@ -143,12 +229,53 @@ func requireLogin(c *web.C, h http.Handler) http.Handler {
 		}
 
 		c.Env["uid"] = uid
+		c.Env["email"], _ = session.Values["email"].(string)
 
 		h.ServeHTTP(w, req)
 	}
 	return http.HandlerFunc(fn)
 }
 
+// requireForm guards form-scoped dashboard routes, letting through users
+// with at least the given role on the form. Forms the logged-in user can't
+// see at all are reported as missing so their IDs can't be probed. The
+// form and the user's role are put in c.Env["form"] and c.Env["role"].
+func requireForm(role string, h web.HandlerFunc) web.HandlerFunc {
+	return func(c web.C, w http.ResponseWriter, req *http.Request) {
+		uid := c.Env["uid"].(string)
+		email := c.Env["email"].(string)
+		id := c.URLParams["id"]
+
+		userRole, err := store.FormRole(id, uid, email)
+		if err != nil {
+			http.Error(w, "Error getting form: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if userRole == "" {
+			http.Error(w, "Form doesn't exist", http.StatusNotFound)
+			return
+		}
+		if !hasRole(userRole, role) {
+			http.Error(w, "You need to be a form "+role+" to do that", http.StatusForbidden)
+			return
+		}
+
+		form, err := store.Form(id)
+		if err == ErrNotFound || form.Deleted != 0 {
+			http.Error(w, "Form doesn't exist", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Error getting form: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		c.Env["form"] = form
+		c.Env["role"] = userRole
+		h(c, w, req)
+	}
+}
+
 func sessionEnv(c *web.C, h http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, req *http.Request) {
 		session, err := rs.Get(req, "session")
@@ -243,6 +370,7 @@ func login(c web.C, w http.ResponseWriter, req *http.Request) {
 		}
 
 		session.Values["uid"] = uid
+		session.Values["email"] = strings.ToLower(email)
 		err = session.Save(req, w)
 		if err != nil {
 			return
@@ -283,12 +411,12 @@ func logout(c web.C, w http.ResponseWriter, req *http.Request) {
 
 func showForms(c web.C, w http.ResponseWriter, req *http.Request) {
 	var (
-		forms []Form
+		forms []FormAccess
 		err   error
 	)
 
 	uid := c.Env["uid"].(string)
-	rc := rp.Get()
+	email := c.Env["email"].(string)
 
 	defer func() {
 		if err != nil {
@@ -297,21 +425,56 @@ func showForms(c web.C, w http.ResponseWriter, req *http.Request) {
 		}
 	}()
 
-	fids, err := redis.Strings(rc.Do(
-		"SMEMBERS",
-		key(uid, "forms"),
-	))
+	fids, err := store.UserForms(uid)
 	if err != nil {
 		return
 	}
 
 	for _, fid := range fids {
 		var form Form
-		err = getForm(rc, key("form", fid), &form)
+		form, err = store.Form(fid)
+		if err == ErrNotFound {
+			err = nil
+			continue
+		}
+		if err != nil {
+			return
+		}
+		forms = append(forms, FormAccess{form, RoleOwner})
+	}
+
+	var sids []string
+	if email != "" {
+		sids, err = store.SharedForms(email)
 		if err != nil {
 			return
 		}
-		forms = append(forms, form)
+	}
+
+	for _, sid := range sids {
+		var (
+			form Form
+			role string
+		)
+		role, err = store.FormRole(sid, uid, email)
+		if err != nil {
+			return
+		}
+		if role == "" || role == RoleOwner {
+			continue
+		}
+		form, err = store.Form(sid)
+		if err == ErrNotFound {
+			err = nil
+			continue
+		}
+		if err != nil {
+			return
+		}
+		if form.Deleted != 0 {
+			continue
+		}
+		forms = append(forms, FormAccess{form, role})
 	}
 
 	r.HTML(w, http.StatusOK, "forms", map[string]interface{}{
@@ -330,7 +493,6 @@ func createForm(c web.C, w http.ResponseWriter, req *http.Request) {
 
 	session := c.Env["session"].(*sessions.Session)
 	uid := c.Env["uid"].(string)
-	rc := rp.Get()
 
 	defer func() {
 		if err != nil {
@@ -342,14 +504,20 @@ func createForm(c web.C, w http.ResponseWriter, req *http.Request) {
 
 		id := genID()
 
-		rc.Do("HMSET", key("form", id),
-			"ID", id,
-			"Name", formName,
-			"RedirectURL", redirectURL,
-			"EmailRecepient", emailRecepient,
-		)
-
-		rc.Do("SADD", key(uid, "forms"), id)
+		err = store.SaveForm(Form{
+			ID:             id,
+			Owner:          uid,
+			Name:           formName,
+			RedirectURL:    redirectURL,
+			EmailRecepient: emailRecepient,
+		})
+		if err == nil {
+			err = store.AddUserForm(uid, id)
+		}
+		if err != nil {
+			http.Error(w, "Error creating form: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
 
 		session.AddFlash("Form created", "success")
 		session.Save(req, w)
@@ -374,17 +542,13 @@ func createForm(c web.C, w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	emailRecepient = req.PostForm.Get("emailRecepient")
+	emailRecepient, err = parseEmailRecepient(req.PostForm)
 }
 
 func showForm(c web.C, w http.ResponseWriter, req *http.Request) {
-	var (
-		form    Form
-		entries []interface{}
-		err     error
-	)
+	var err error
 
-	rc := rp.Get()
+	form := c.Env["form"].(Form)
 
 	defer func() {
 		if err != nil {
@@ -393,77 +557,77 @@ func showForm(c web.C, w http.ResponseWriter, req *http.Request) {
 		}
 	}()
 
-	err = getForm(rc, key("form", c.URLParams["id"]), &form)
+	formURL := createURL(req)
+	formURL.Path = fmt.Sprintf("/s/%s", form.ID)
+
+	fields, err := store.Fields(form.ID)
 	if err != nil {
 		return
 	}
 
-	if form == (Form{}) {
-		http.Error(w, "Form doesn't exist", http.StatusNotFound)
+	query := req.URL.Query()
+	page, err := pageEntries(store.Entries, form.ID, query)
+	if _, ok := err.(queryError); ok {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		err = nil
+		return
+	}
+	if err != nil {
 		return
 	}
 
-	formURL := createURL(req)
-	formURL.Path = fmt.Sprintf("/s/%s", form.ID)
-
-	fields, err := redis.Strings(rc.Do(
-		"SMEMBERS",
-		key("form", form.ID, "fields"),
-	))
+	purge, err := nextPurge(form)
 	if err != nil {
 		return
 	}
+	if now := time.Now().UTC().Unix(); purge != 0 && purge < now {
+		purge = now
+	}
 
-	v, err := redis.Values(rc.Do(
-		"ZREVRANGEBYSCORE",
-		key("form", form.ID, "entries"),
-		"+inf", "-inf", "WITHSCORES",
-	))
+	rate, err := formRateCounts(form, time.Now())
 	if err != nil {
 		return
 	}
-	ems := make([]EntryMeta, len(v)/2)
-	for i := range ems {
-		v, err = redis.Scan(v, &ems[i].ID, &ems[i].Submitted)
+
+	var captcha *captchaProvider
+	if p, ok := findCaptchaProvider(form.CaptchaProvider); ok {
+		captcha = &p
 	}
 
-	for _, em := range ems {
-		v, err := redis.Strings(
-			rc.Do("HGETALL", key("form", form.ID, "entry", em.ID)),
-		)
+	var collaborators []Collaborator
+	if c.Env["role"] == RoleOwner {
+		collaborators, err = store.Collaborators(form.ID)
 		if err != nil {
 			return
 		}
-
-		entry := map[string]interface{}{
-			"Submitted": time.Unix(em.Submitted, 0).UTC().Format(time.Stamp),
-		}
-		for i := 0; i < len(v); i += 2 {
-			entry[v[i]] = v[i+1]
-		}
-
-		entries = append(entries, entry)
 	}
 
 	r.HTML(w, http.StatusOK, "form", map[string]interface{}{
-		"Form":     form,
-		"FormURL":  formURL.String(),
-		"Fields":   fields,
-		"Entries":  entries,
-		"Messages": getMessages(c, w, req),
+		"Form":          form,
+		"Role":          c.Env["role"],
+		"Collaborators": collaborators,
+		"FormURL":       formURL.String(),
+		"Fields":        fields,
+		"Entries":       page.Entries,
+		"Newer":         page.Newer,
+		"Older":         page.Older,
+		"Since":         query.Get("since"),
+		"Until":         query.Get("until"),
+		"Filters":       filterValues(query),
+		"Exports":       exportLinks(form.ID, query),
+		"NextPurge":     purge,
+		"Rate":          rate,
+		"Captcha":       captcha,
+		"Sender":        defaultSender(),
+		"Messages":      getMessages(c, w, req),
 	})
 }
 
 func updateForm(c web.C, w http.ResponseWriter, req *http.Request) {
-	var (
-		formName       string
-		redirectURL    string
-		emailRecepient string
-		err            error
-	)
+	var err error
 
 	session := c.Env["session"].(*sessions.Session)
-	rc := rp.Get()
+	form := c.Env["form"].(Form)
 
 	defer func() {
 		if err != nil {
@@ -473,11 +637,23 @@ func updateForm(c web.C, w http.ResponseWriter, req *http.Request) {
 			return
 		}
 
-		rc.Do("HMSET", key("form", c.URLParams["id"]),
-			"Name", formName,
-			"RedirectURL", redirectURL,
-			"EmailRecepient", emailRecepient,
-		)
+		// Digests and retention move their cursors on in the background, so
+		// the stored ones are kept unless the new settings start them over.
+		stored, err := store.Form(form.ID)
+		if err == nil {
+			if form.RetentionFields == stored.RetentionFields {
+				form.AnonymizedUntil = stored.AnonymizedUntil
+			}
+			if form.NotifyMode == "" || stored.NotifyMode != "" {
+				form.DigestedUntil = stored.DigestedUntil
+			}
+			err = store.SaveForm(form)
+		}
+		if err != nil {
+			http.Error(w, "Error updating form: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		c.Env["form"] = form
 
 		session.AddFlash("Form updated", "info")
 		session.Save(req, w)
@@ -488,19 +664,63 @@ func updateForm(c web.C, w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	formName = req.PostForm.Get("formName")
-	if formName == "" {
+	form.Name = req.PostForm.Get("formName")
+	if form.Name == "" {
 		err = errors.New("Form name can't be empty")
 		return
 	}
 
-	redirectURL = req.PostForm.Get("redirectURL")
-	if redirectURL == "" {
+	form.RedirectURL = req.PostForm.Get("redirectURL")
+	if form.RedirectURL == "" {
 		err = errors.New("Redirect URL can't be empty")
 		return
 	}
 
-	emailRecepient = req.PostForm.Get("emailRecepient")
+	if form.EmailRecepient, err = parseEmailRecepient(req.PostForm); err != nil {
+		return
+	}
+
+	if err = parseRetention(&form, req.PostForm); err != nil {
+		return
+	}
+
+	if err = parseUploads(&form, req.PostForm); err != nil {
+		return
+	}
+
+	if err = parseOrigins(&form, req.PostForm); err != nil {
+		return
+	}
+
+	if err = parseSpamFilter(&form, req.PostForm); err != nil {
+		return
+	}
+
+	if err = parseRateLimits(&form, req.PostForm); err != nil {
+		return
+	}
+
+	if err = parseCaptcha(&form, req.PostForm); err != nil {
+		return
+	}
+
+	if err = parseProofOfWork(&form, req.PostForm); err != nil {
+		return
+	}
+
+	if err = parseEmailTemplates(&form, req.PostForm); err != nil {
+		return
+	}
+
+	if err = parseEmailSender(&form, req.PostForm); err != nil {
+		return
+	}
+
+	if err = parseAutoreply(&form, req.PostForm); err != nil {
+		return
+	}
+
+	err = parseNotifyMode(&form, req.PostForm)
 }
 
 func deleteForm(c web.C, w http.ResponseWriter, req *http.Request) {
@@ -510,7 +730,7 @@ func deleteForm(c web.C, w http.ResponseWriter, req *http.Request) {
 
 	session := c.Env["session"].(*sessions.Session)
 	uid := c.Env["uid"].(string)
-	rc := rp.Get()
+	form := c.Env["form"].(Form)
 
 	defer func() {
 		if err != nil {
@@ -518,20 +738,17 @@ func deleteForm(c web.C, w http.ResponseWriter, req *http.Request) {
 			session.Save(req, w)
 			return
 		}
-		session.AddFlash("Form deleted", "success")
+		session.AddFlash("Form moved to the trash", "success")
 		session.Save(req, w)
 	}()
 
-	_, err = rc.Do("SADD", key(uid, "deletedForms"), c.URLParams["id"])
-	if err != nil {
-		return
-	}
-
-	_, err = rc.Do("SREM", key(uid, "forms"), c.URLParams["id"])
-	if err != nil {
+	form.Owner = uid
+	form.Deleted = time.Now().UTC().Unix()
+	if err = store.SaveForm(form); err != nil {
 		return
 	}
 
+	err = store.DeleteUserForm(uid, form.ID)
 }
 
 // Submit
@@ -542,58 +759,196 @@ func submitEntry(c web.C, w http.ResponseWriter, req *http.Request) {
 		err  error
 	)
 
-	rc := rp.Get()
-
 	defer func() {
 		if err != nil {
-			http.Error(w, "Error submitting entry: "+err.Error(), http.StatusInternalServerError)
-			return
+			rejectEntry(w, req, form, http.StatusInternalServerError,
+				"Error submitting entry: "+err.Error(), nil)
 		}
 	}()
 
-	err = getForm(rc, key("form", c.URLParams["id"]), &form)
+	form, err = store.Form(c.URLParams["id"])
+	if err == ErrNotFound || form.Deleted != 0 {
+		err = nil
+		rejectEntry(w, req, form, http.StatusNotFound, "Form doesn't exist", nil)
+		return
+	}
 	if err != nil {
 		return
 	}
 
-	if form == (Form{}) {
-		http.Error(w, "Form doesn't exist", http.StatusNotFound)
+	origin := requestOrigin(req)
+	if !originAllowed(form, origin) {
+		rejectEntry(w, req, form, http.StatusForbidden, "Submissions from this site aren't allowed", nil)
 		return
 	}
+	setCORSHeaders(w, form, origin)
 
-	if err := req.ParseForm(); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	retry, err := limitSubmission(form, clientIP(req), time.Now())
+	if err != nil {
+		return
+	}
+	if retry > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int((retry+time.Second-1)/time.Second)))
+		rejectEntry(w, req, form, http.StatusTooManyRequests, "Too many submissions, try again later", nil)
 		return
 	}
 
-	eid := genID()
+	files, err := parseSubmission(w, req, form)
+	switch err {
+	case nil:
+	case errUploadTooLarge:
+		err = nil
+		rejectEntry(w, req, form, http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("Uploads can't be larger than %d MB", form.MaxUploadMB), nil)
+		return
+	case errEntryTooLarge:
+		err = nil
+		rejectEntry(w, req, form, http.StatusRequestEntityTooLarge, errEntryTooLarge.Error(), nil)
+		return
+	default:
+		msg := err.Error()
+		err = nil
+		rejectEntry(w, req, form, http.StatusBadRequest, msg, nil)
+		return
+	}
+	if req.MultipartForm != nil {
+		defer req.MultipartForm.RemoveAll()
+	}
 
-	entry := []interface{}{key("form", form.ID, "entry", eid)}
-	emailBody := fmt.Sprintf(`%s
----
+	values, multi := fieldValues(req.PostForm)
 
-`, form.Name)
-	for field := range req.PostForm {
-		value := req.PostForm.Get(field)
-		entry = append(entry, field, value)
-		emailBody += fmt.Sprintf("%s: %s\n", field, value)
-		rc.Do("SADD", key("form", form.ID, "fields"), field)
+	solved, err := verifyCaptcha(form, values, clientIP(req))
+	if err != nil {
+		return
+	}
+	if !solved {
+		rejectEntry(w, req, form, http.StatusBadRequest, "CAPTCHA wasn't solved", nil)
+		return
 	}
-	rc.Do("HMSET", entry...)
 
-	rc.Do("ZADD", key("form", form.ID, "entries"), time.Now().UTC().Unix(), eid)
+	nonce, solved := verifyProofOfWork(form, values, time.Now())
+	if !solved {
+		rejectEntry(w, req, form, http.StatusBadRequest, "Proof of work is missing or invalid", nil)
+		return
+	}
 
-	if form.EmailRecepient != "" {
-		m := mailgun.NewMessage(
-			"Formic <formic@marksteve.com>",
-			fmt.Sprintf("[Formic] New entry for %s", form.Name),
-			emailBody,
-			form.EmailRecepient,
-		)
-		gun.Send(m)
+	reason := spamReason(form, values, time.Now())
+	delete(values, spamTokenField)
+	if form.HoneypotField != "" {
+		delete(values, form.HoneypotField)
+	}
+	// Checked once Formic's own fields are gone, so no entry is stored
+	// without values.
+	if len(values) == 0 && len(files) == 0 {
+		rejectEntry(w, req, form, http.StatusBadRequest, "Entry has no fields", nil)
+		return
+	}
+	// Values can't pass for attachments or lists, even in spam as it can
+	// be restored.
+	if errs := checkReserved(nil, values); errs != nil {
+		rejectEntry(w, req, form, http.StatusBadRequest, "Entry is invalid", errs)
+		return
+	}
+	// Spam is kept without its files and answered like any entry so bots
+	// can't tell it was caught.
+	if reason != "" {
+		solved, err = usePowNonce(form, nonce, time.Now())
+		if err != nil {
+			return
+		}
+		if !solved {
+			rejectEntry(w, req, form, http.StatusBadRequest, "Proof of work was already used", nil)
+			return
+		}
+		entry := Entry{
+			ID:        genID(),
+			Submitted: time.Now().UTC().Unix(),
+			Values:    make(map[string]string, len(values)),
+		}
+		for field, vals := range values {
+			entry.Values[field] = listValue(vals, multi[field])
+		}
+		if err = store.AddSpam(form.ID, entry); err != nil {
+			return
+		}
+		log.Printf("Caught spam %s for form %s: %s", entry.ID, form.ID, reason)
+		acceptEntry(w, req, form, entry)
+		return
+	}
+
+	// Files are validated by name until they're saved.
+	checked := url.Values{}
+	for field, vals := range values {
+		checked[field] = vals
+	}
+	for field, fhs := range files {
+		for _, fh := range fhs {
+			checked.Add(field, Attachment{Name: fh.Filename}.value())
+		}
 	}
 
-	http.Redirect(w, req, form.RedirectURL, http.StatusFound)
+	schema, err := store.Schema(form.ID)
+	if err != nil {
+		return
+	}
+	if errs := validateEntry(schema, checked); errs != nil {
+		rejectEntry(w, req, form, http.StatusBadRequest, "Entry is invalid", errs)
+		return
+	}
+
+	solved, err = usePowNonce(form, nonce, time.Now())
+	if err != nil {
+		return
+	}
+	if !solved {
+		rejectEntry(w, req, form, http.StatusBadRequest, "Proof of work was already used", nil)
+		return
+	}
+
+	entry := Entry{
+		ID:        genID(),
+		Submitted: time.Now().UTC().Unix(),
+		Values:    make(map[string]string),
+	}
+
+	attachments, err := saveUploads(form, entry.ID, files)
+	if errs, ok := err.(FieldErrors); ok {
+		err = nil
+		rejectEntry(w, req, form, http.StatusBadRequest, "Entry is invalid", errs)
+		return
+	}
+	if err != nil {
+		return
+	}
+	for field, as := range attachments {
+		for _, a := range as {
+			values.Add(field, a.value())
+		}
+		if len(as) > 1 {
+			multi[field] = true
+		}
+	}
+
+	for field, vals := range values {
+		entry.Values[field] = listValue(vals, multi[field])
+	}
+
+	err = store.AddEntry(form.ID, entry)
+	if err != nil {
+		deleteAttachments(form.ID, entry.ID, attachments)
+		return
+	}
+
+	if form.EmailRecepient != "" && form.NotifyMode == "" {
+		if err := notifyEntry(form, entry, createURL(req)); err != nil {
+			log.Printf("Error notifying %s of entry %s: %s", form.EmailRecepient, entry.ID, err.Error())
+		}
+	}
+	if err := autoreplyEntry(form, entry, createURL(req)); err != nil {
+		log.Printf("Error sending autoreply to entry %s: %s", entry.ID, err.Error())
+	}
+
+	acceptEntry(w, req, form, entry)
 }
 
 // Init
@@ -603,12 +958,23 @@ func init() {
 		Layout: "layout",
 		Funcs: []template.FuncMap{
 			template.FuncMap{
-				"Title": strings.Title,
+				"Title":   strings.Title,
+				"Date":    formatDate,
+				"Time":    formatTime,
+				"ToUpper": strings.ToUpper,
+				"File":    parseAttachment,
+				"Files":   entryAttachments,
+				"Items":   parseList,
+				"Label":   fieldLabel,
+				"Lines":   entryLines,
 			},
 		},
 		IsDevelopment: true,
 	})
-	rp = &redis.Pool{
+}
+
+func newRedisPool() *redis.Pool {
+	return &redis.Pool{
 		MaxIdle:     3,
 		IdleTimeout: 240 * time.Second,
 		Dial: func() (redis.Conn, error) {
@@ -626,7 +992,6 @@ func init() {
 			return err
 		},
 	}
-	rs, _ = redistore.NewRediStoreWithPool(rp, []byte(*sessionSecret))
 }
 
 // Start
@@ -642,14 +1007,62 @@ func main() {
 		os.Exit(1)
 	}
 
+	if *storeType == "redis" {
+		rp = newRedisPool()
+		rs, err = redistore.NewRediStoreWithPool(rp, []byte(*sessionSecret))
+	} else {
+		rs = sessions.NewCookieStore([]byte(*sessionSecret))
+	}
+	if err == nil {
+		store, err = newStore(*storeType)
+	}
+	if err != nil {
+		fmt.Printf(
+			"Error opening %s store: %s\n",
+			*storeType,
+			err.Error(),
+		)
+		os.Exit(1)
+	}
+
+	limiter = newRateLimiter(*storeType)
+	trustedProxyNets, err = parseTrustedProxies(*trustedProxies)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	blobs, err = newBlobStore(*blobStoreType)
+	if err != nil {
+		fmt.Printf(
+			"Error opening %s blob store: %s\n",
+			*blobStoreType,
+			err.Error(),
+		)
+		os.Exit(1)
+	}
+
+	notifier, err = newNotifier(*notifierType)
+	if err != nil {
+		fmt.Printf("Error setting up notifier: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	flag.Parse()
+	if flag.NArg() > 0 {
+		if err := runCommand(flag.Args()); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
 	missingConfig := make([]string, 0)
 	for n, v := range map[string]string{
 		"Session Secret":        *sessionSecret,
 		"Google Client ID":      *googleClientID,
 		"Google Client Secret":  *googleClientSecret,
 		"Google Allowed Emails": *googleAllowedEmails,
-		"Mailgun Domain":        *mailgunDomain,
-		"Mailgun API Key":       *mailgunKey,
 	} {
 		if v == "" {
 			missingConfig = append(missingConfig, n)
@@ -663,12 +1076,6 @@ func main() {
 		os.Exit(1)
 	}
 
-	gun = mailgun.NewMailgun(
-		*mailgunDomain,
-		*mailgunKey,
-		"",
-	)
-
 	goji.Get("/", index)
 	goji.Get("/oauth2callback", login)
 	goji.Get("/logout", logout)
@@ -679,12 +1086,33 @@ func main() {
 	dashboard.Use(requireLogin)
 	dashboard.Get("/", showForms)
 	dashboard.Post("/", createForm)
-	dashboard.Get("/:id", showForm)
-	dashboard.Post("/:id", updateForm)
-	dashboard.Delete("/:id", deleteForm)
+	dashboard.Get("/trash", showTrash)
+	dashboard.Post("/trash/:id", restoreForm)
+	dashboard.Get("/:id", requireForm(RoleViewer, showForm))
+	dashboard.Post("/:id", requireForm(RoleEditor, updateForm))
+	dashboard.Delete("/:id", requireForm(RoleOwner, deleteForm))
+	dashboard.Get("/:id/schema", requireForm(RoleEditor, showSchema))
+	dashboard.Post("/:id/schema", requireForm(RoleEditor, updateSchema))
+	dashboard.Post("/:id/email", requireForm(RoleEditor, previewEmail))
+	dashboard.Get("/:id/export", requireForm(RoleViewer, exportEntries))
+	dashboard.Get("/:id/spam", requireForm(RoleViewer, showSpam))
+	dashboard.Post("/:id/spam/:eid", requireForm(RoleEditor, restoreSpam))
+	dashboard.Delete("/:id/spam/:eid", requireForm(RoleEditor, deleteSpam))
+	dashboard.Get("/:id/entries/:eid", requireForm(RoleViewer, requireEntry(showEntry)))
+	dashboard.Post("/:id/entries/:eid", requireForm(RoleEditor, requireEntry(updateEntry)))
+	dashboard.Delete("/:id/entries/:eid", requireForm(RoleEditor, requireEntry(deleteEntry)))
+	dashboard.Get("/:id/entries/:eid/files/:field", requireForm(RoleViewer, requireEntry(downloadAttachment)))
+	dashboard.Post("/:id/import", requireForm(RoleEditor, uploadEntries))
+	dashboard.Post("/:id/collaborators", requireForm(RoleOwner, inviteCollaborator))
+	dashboard.Delete("/:id/collaborators/:email", requireForm(RoleOwner, removeCollaborator))
 	goji.Handle("/dashboard/*", dashboard)
 
 	goji.Post("/s/:id", submitEntry)
+	goji.Options("/s/:id", preflightEntry)
+	goji.Get("/s/:id/token", showSpamToken)
+	goji.Get("/s/:id/token.js", showSpamTokenScript)
+	goji.Get("/s/:id/challenge", showPowChallenge)
+	goji.Get("/s/:id/pow.js", showPowScript)
 
 	goji.Get("/static/lib/*", http.StripPrefix(
 		"/static/lib/",
@@ -699,5 +1127,10 @@ func main() {
 		),
 	))
 
+	every(time.Hour, purgeTrash)
+	every(time.Hour, enforceRetention)
+	every(time.Hour, purgeSpam)
+	every(5*time.Minute, sendDigests)
+
 	goji.Serve()
 }
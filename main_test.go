@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"github.com/zenazn/goji/web"
+)
+
+// newTestForm starts a memory store with a form that takes entries from
+// anywhere.
+func newTestForm(t *testing.T, form Form) Form {
+	store = newMemoryStore()
+	limiter = newMemoryRateLimiter()
+	form.ID = "form"
+	form.Name = "Contact"
+	form.RedirectURL = "http://example.com/thanks"
+	if err := store.SaveForm(form); err != nil {
+		t.Fatal(err)
+	}
+	return form
+}
+
+// testSession returns a session for dashboard handlers to add flashes to.
+func testSession(req *http.Request) *sessions.Session {
+	session, _ := sessions.NewCookieStore([]byte("secret")).New(req, "formic")
+	return session
+}
+
+// updateTestForm posts the form's settings page with values on top of the
+// ones it needs, returning the stored form.
+func updateTestForm(t *testing.T, form Form, values url.Values) Form {
+	posted := url.Values{
+		"formName":        {form.Name},
+		"redirectURL":     {form.RedirectURL},
+		"retentionAction": {RetentionDelete},
+	}
+	for k, v := range values {
+		posted[k] = v
+	}
+	req, err := http.NewRequest("POST", "/dashboard/"+form.ID, strings.NewReader(posted.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	c := web.C{Env: map[string]interface{}{"session": testSession(req), "form": form, "role": "owner"}}
+	updateForm(c, httptest.NewRecorder(), req)
+
+	stored, err := store.Form(form.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stored
+}
+
+func submit(t *testing.T, form Form, values url.Values) (int, submitResult) {
+	req, err := http.NewRequest("POST", "/s/"+form.ID, strings.NewReader(values.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.RemoteAddr = "192.0.2.1:1234"
+	w := httptest.NewRecorder()
+	submitEntry(web.C{URLParams: map[string]string{"id": form.ID}}, w, req)
+
+	var result submitResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("%d %q: %s", w.Code, w.Body.String(), err.Error())
+	}
+	return w.Code, result
+}
+
+func TestSubmitEntry(t *testing.T) {
+	form := newTestForm(t, Form{})
+
+	code, result := submit(t, form, url.Values{
+		"name":          {"Mark"},
+		"tags[]":        {"a", "b"},
+		"address[city]": {"Manila"},
+	})
+	if code != http.StatusCreated || result.ID == "" {
+		t.Fatalf("got %d %+v; want 201 with an ID", code, result)
+	}
+	entry, err := store.Entry(form.ID, result.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"name":         "Mark",
+		"tags":         listValue([]string{"a", "b"}, true),
+		"address.city": "Manila",
+	}
+	for field, value := range want {
+		if entry.Values[field] != value {
+			t.Errorf("%s = %q; want %q", field, entry.Values[field], value)
+		}
+	}
+}
+
+func TestSubmitEntryValidation(t *testing.T) {
+	form := newTestForm(t, Form{})
+	schema := []FieldSpec{
+		{Name: "email", Type: FieldEmail, Required: true},
+		{Name: "message", MaxLength: 5},
+	}
+	if err := store.SaveSchema(form.ID, schema); err != nil {
+		t.Fatal(err)
+	}
+
+	code, result := submit(t, form, url.Values{"message": {"Too long"}})
+	if code != http.StatusBadRequest {
+		t.Fatalf("got %d; want 400", code)
+	}
+	if result.Fields["email"] != "is required" || result.Fields["message"] == "" {
+		t.Errorf("fields = %v; want email and message errors", result.Fields)
+	}
+
+	code, _ = submit(t, form, url.Values{"email": {"mark@example.com"}, "message": {"Hi"}})
+	if code != http.StatusCreated {
+		t.Errorf("got %d; want 201", code)
+	}
+	if entries, _ := store.Entries(form.ID, EntryQuery{}); len(entries) != 1 {
+		t.Errorf("got %d entries; want 1", len(entries))
+	}
+}
+
+func TestSubmitEntryReserved(t *testing.T) {
+	form := newTestForm(t, Form{HoneypotField: "website"})
+
+	forged := []url.Values{
+		{"file": {attachmentPrefix + `{"Key":"other/entry/file"}`}},
+		{"tags": {listPrefix + `["a"]`}},
+		// Caught as spam, which can be restored as an entry.
+		{"file": {attachmentPrefix + `{"Key":"other/entry/file"}`}, "website": {"spam"}},
+	}
+	for _, values := range forged {
+		code, result := submit(t, form, values)
+		if code != http.StatusBadRequest || len(result.Fields) != 1 {
+			t.Errorf("%v: got %d %+v; want 400 with a field error", values, code, result)
+		}
+	}
+	if entries, _ := store.Entries(form.ID, EntryQuery{}); len(entries) != 0 {
+		t.Errorf("got %d entries; want none", len(entries))
+	}
+	if spam, _ := store.Spam(form.ID, EntryQuery{}); len(spam) != 0 {
+		t.Errorf("got %d spam; want none", len(spam))
+	}
+}
+
+func TestSubmitEntrySpam(t *testing.T) {
+	form := newTestForm(t, Form{HoneypotField: "website"})
+
+	code, result := submit(t, form, url.Values{"name": {"Bot"}, "website": {"http://spam.example.com"}})
+	if code != http.StatusCreated || result.ID == "" {
+		t.Fatalf("got %d %+v; want spam answered like an entry", code, result)
+	}
+	if entries, _ := store.Entries(form.ID, EntryQuery{}); len(entries) != 0 {
+		t.Errorf("got %d entries; want none", len(entries))
+	}
+	spam, _ := store.Spam(form.ID, EntryQuery{})
+	if len(spam) != 1 || spam[0].ID != result.ID {
+		t.Fatalf("spam = %v; want the submission", spam)
+	}
+	if _, ok := spam[0].Values["website"]; ok || spam[0].Values["name"] != "Bot" {
+		t.Errorf("spam values = %v; want name without the honeypot", spam[0].Values)
+	}
+
+	code, _ = submit(t, form, url.Values{"name": {"Mark"}, "website": {""}})
+	if code != http.StatusCreated {
+		t.Errorf("got %d; want 201", code)
+	}
+	if entries, _ := store.Entries(form.ID, EntryQuery{}); len(entries) != 1 {
+		t.Errorf("got %d entries; want 1", len(entries))
+	}
+}
+
+func TestSubmitEntryNotFound(t *testing.T) {
+	form := newTestForm(t, Form{})
+	form.ID = "missing"
+	if code, _ := submit(t, form, url.Values{"name": {"Mark"}}); code != http.StatusNotFound {
+		t.Errorf("got %d; want 404", code)
+	}
+}
+
+func TestSubmitEntryEmpty(t *testing.T) {
+	form := newTestForm(t, Form{HoneypotField: "website"})
+
+	for _, values := range []url.Values{
+		{},
+		{"website": {""}},
+		{spamTokenField: {"token"}, "website": {""}},
+	} {
+		if code, result := submit(t, form, values); code != http.StatusBadRequest {
+			t.Errorf("%v: got %d %+v; want 400", values, code, result)
+		}
+	}
+	if entries, _ := store.Entries(form.ID, EntryQuery{}); len(entries) != 0 {
+		t.Errorf("got %d entries; want none", len(entries))
+	}
+}
+
+func TestSubmitEntryTrashed(t *testing.T) {
+	form := newTestForm(t, Form{Deleted: 1000})
+	if code, _ := submit(t, form, url.Values{"name": {"Mark"}}); code != http.StatusNotFound {
+		t.Errorf("got %d; want 404", code)
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	osexec "os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mailgun/mailgun-go"
+)
+
+// errNotifierDisabled is returned by notifiers when no backend is set up.
+var errNotifierDisabled = errors.New("notification emails aren't set up")
+
+// Notification is an email sent by Formic.
+type Notification struct {
+	From    string
+	To      []string
+	ReplyTo string
+	Subject string
+	Text    string
+	// HTML is an optional alternative to Text.
+	HTML string
+}
+
+// Notifier sends notification emails.
+type Notifier interface {
+	Notify(n Notification) error
+}
+
+// newNotifier returns the backend named by the notifier config. Without one,
+// Mailgun is used if it's configured and emails aren't sent otherwise.
+func newNotifier(backend string) (Notifier, error) {
+	if _, err := mail.ParseAddress(*notifyFrom); err != nil {
+		return nil, fmt.Errorf("invalid notify-from %q", *notifyFrom)
+	}
+	if backend == "" {
+		backend = "none"
+		if *mailgunDomain != "" {
+			backend = "mailgun"
+		}
+	}
+	switch backend {
+	case "none":
+		return noNotifier{}, nil
+	case "mailgun":
+		if *mailgunDomain == "" || *mailgunKey == "" {
+			return nil, errors.New("mailgun-domain and mailgun-key are needed")
+		}
+		return mailgunNotifier{mailgun.NewMailgun(*mailgunDomain, *mailgunKey, "")}, nil
+	case "smtp":
+		if *smtpHost == "" {
+			return nil, errors.New("smtp-host is needed")
+		}
+		return smtpNotifier{
+			addr:     net.JoinHostPort(*smtpHost, strconv.Itoa(*smtpPort)),
+			host:     *smtpHost,
+			username: *smtpUsername,
+			password: *smtpPassword,
+		}, nil
+	case "sendmail":
+		return sendmailNotifier{*sendmailPath}, nil
+	}
+	return nil, fmt.Errorf("unknown notifier %q", backend)
+}
+
+// notify sends a notification, from the configured sender unless it has
+// another.
+func notify(n Notification) error {
+	if notifier == nil {
+		return errNotifierDisabled
+	}
+	if n.From == "" {
+		n.From = *notifyFrom
+	}
+	return notifier.Notify(n)
+}
+
+type noNotifier struct{}
+
+func (noNotifier) Notify(n Notification) error {
+	return errNotifierDisabled
+}
+
+type mailgunNotifier struct {
+	gun mailgun.Mailgun
+}
+
+func (m mailgunNotifier) Notify(n Notification) error {
+	msg := mailgun.NewMessage(n.From, n.Subject, n.Text, n.To...)
+	if n.HTML != "" {
+		msg.SetHtml(n.HTML)
+	}
+	if n.ReplyTo != "" {
+		msg.AddHeader("Reply-To", n.ReplyTo)
+	}
+	_, _, err := m.gun.Send(msg)
+	return err
+}
+
+// message formats the notification for SMTP servers and sendmail, with
+// the HTML body as an alternative to the text if there's one.
+func (n Notification) message(now time.Time) []byte {
+	var b bytes.Buffer
+	headers := [][2]string{
+		{"From", n.From},
+		{"To", strings.Join(n.To, ", ")},
+		{"Subject", mime.QEncoding.Encode("utf-8", n.Subject)},
+		{"Date", now.Format(time.RFC1123Z)},
+		{"Message-ID", fmt.Sprintf("<%d.%s@formic>", now.UnixNano(), genID())},
+		{"MIME-Version", "1.0"},
+	}
+	if n.ReplyTo != "" {
+		headers = append(headers, [2]string{"Reply-To", n.ReplyTo})
+	}
+	for _, h := range headers {
+		fmt.Fprintf(&b, "%s: %s\r\n", h[0], h[1])
+	}
+	if n.HTML == "" {
+		writeMessagePart(&b, "text/plain", n.Text)
+		return b.Bytes()
+	}
+
+	mw := multipart.NewWriter(&b)
+	fmt.Fprintf(&b, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", mw.Boundary())
+	for _, part := range [][2]string{{"text/plain", n.Text}, {"text/html", n.HTML}} {
+		w, _ := mw.CreatePart(nil)
+		writeMessagePart(w, part[0], part[1])
+	}
+	mw.Close()
+	return b.Bytes()
+}
+
+// writeMessagePart writes the headers and quoted-printable body of a part.
+func writeMessagePart(w io.Writer, contentType, body string) {
+	fmt.Fprintf(w, "Content-Type: %s; charset=utf-8\r\n", contentType)
+	fmt.Fprintf(w, "Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	qp := quotedprintable.NewWriter(w)
+	qp.Write([]byte(strings.Replace(body, "\n", "\r\n", -1)))
+	qp.Close()
+}
+
+// envelope returns the bare sender and recipient addresses.
+func (n Notification) envelope() (from string, to []string, err error) {
+	addr, err := mail.ParseAddress(n.From)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid sender %q", n.From)
+	}
+	for _, rcpt := range n.To {
+		a, err := mail.ParseAddress(rcpt)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid recipient %q", rcpt)
+		}
+		to = append(to, a.Address)
+	}
+	return addr.Address, to, nil
+}
+
+// smtpNotifier sends emails through an SMTP server, over TLS on port 465
+// and upgrading with STARTTLS elsewhere if the server offers it.
+type smtpNotifier struct {
+	addr     string
+	host     string
+	username string
+	password string
+}
+
+func (s smtpNotifier) Notify(n Notification) error {
+	from, to, err := n.envelope()
+	if err != nil {
+		return err
+	}
+
+	var conn net.Conn
+	tlsConfig := &tls.Config{ServerName: s.host}
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	if strings.HasSuffix(s.addr, ":465") {
+		conn, err = tls.DialWithDialer(dialer, "tcp", s.addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", s.addr)
+	}
+	if err != nil {
+		return err
+	}
+	c, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err = c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if s.username != "" {
+		if err = c.Auth(smtp.PlainAuth("", s.username, s.password, s.host)); err != nil {
+			return err
+		}
+	}
+	if err = c.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err = c.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	wc, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = wc.Write(n.message(time.Now())); err != nil {
+		return err
+	}
+	if err = wc.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// sendmailNotifier pipes emails to a local sendmail compatible program.
+type sendmailNotifier struct {
+	path string
+}
+
+func (s sendmailNotifier) Notify(n Notification) error {
+	from, to, err := n.envelope()
+	if err != nil {
+		return err
+	}
+	cmd := osexec.Command(s.path, append([]string{"-i", "-f", from, "--"}, to...)...)
+	cmd.Stdin = bytes.NewReader(n.message(time.Now()))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %s %s", s.path, err.Error(), bytes.TrimSpace(out))
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNotificationMessage(t *testing.T) {
+	n := Notification{
+		From:    `"Formic" <forms@example.com>`,
+		To:      []string{"<mark@example.com>", "Steve <steve@example.com>"},
+		ReplyTo: "<jane@example.com>",
+		Subject: "New entry for Café",
+		Text:    "Hi\nthere",
+		HTML:    "<p>Hi</p>",
+	}
+	msg := string(n.message(time.Unix(0, 0)))
+	for _, s := range []string{
+		"From: \"Formic\" <forms@example.com>\r\n",
+		"To: <mark@example.com>, Steve <steve@example.com>\r\n",
+		"Reply-To: <jane@example.com>\r\n",
+		"Subject: =?utf-8?q?New_entry_for_Caf=C3=A9?=\r\n",
+		"Content-Type: multipart/alternative; boundary=",
+		"Content-Type: text/plain; charset=utf-8\r\n",
+		"Hi\r\nthere",
+		"Content-Type: text/html; charset=utf-8\r\n",
+	} {
+		if !strings.Contains(msg, s) {
+			t.Errorf("message doesn't have %q:\n%s", s, msg)
+		}
+	}
+
+	from, to, err := n.envelope()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from != "forms@example.com" || strings.Join(to, " ") != "mark@example.com steve@example.com" {
+		t.Errorf("envelope = %s, %v", from, to)
+	}
+	n.To = []string{"mark"}
+	if _, _, err := n.envelope(); err == nil {
+		t.Error("invalid recipient was accepted")
+	}
+}
+
+func TestNotifyDisabled(t *testing.T) {
+	defer func(saved Notifier) { notifier = saved }(notifier)
+	notifier = nil
+	if err := notify(Notification{}); err != errNotifierDisabled {
+		t.Errorf("got %v; want errNotifierDisabled", err)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/zenazn/goji/web"
+)
+
+// parseOrigins reads the sites a form takes submissions from, written as
+// origins like https://example.com.
+func parseOrigins(form *Form, values url.Values) error {
+	origins := splitList(values.Get("allowedOrigins"))
+	for i, origin := range origins {
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" ||
+			u.Path != "" && u.Path != "/" || u.RawQuery != "" {
+			return fmt.Errorf("%s isn't an origin like https://example.com", origin)
+		}
+		origins[i] = strings.ToLower(u.Scheme + "://" + u.Host)
+	}
+	form.AllowedOrigins = strings.Join(origins, ", ")
+	return nil
+}
+
+// requestOrigin returns the origin a request was sent from, falling back to
+// the Referer for browsers that leave out Origin.
+func requestOrigin(req *http.Request) string {
+	if origin := req.Header.Get("Origin"); origin != "" {
+		return strings.ToLower(origin)
+	}
+	u, err := url.Parse(req.Referer())
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return ""
+	}
+	return strings.ToLower(u.Scheme + "://" + u.Host)
+}
+
+// originAllowed reports whether a form takes submissions from origin. Forms
+// without allowed origins take them from anywhere, while others turn away
+// requests that don't say where they're from.
+func originAllowed(form Form, origin string) bool {
+	origins := splitList(form.AllowedOrigins)
+	if len(origins) == 0 {
+		return true
+	}
+	for _, o := range origins {
+		if o == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// setCORSHeaders lets scripts on the sites a form allows read the results
+// of their submissions.
+func setCORSHeaders(w http.ResponseWriter, form Form, origin string) {
+	h := w.Header()
+	if form.AllowedOrigins == "" {
+		h.Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	h.Add("Vary", "Origin")
+	h.Set("Access-Control-Allow-Origin", origin)
+}
+
+// preflightEntry answers the OPTIONS requests browsers send before
+// cross-origin submissions with JSON bodies or custom headers.
+func preflightEntry(c web.C, w http.ResponseWriter, req *http.Request) {
+	form, err := store.Form(c.URLParams["id"])
+	if err == ErrNotFound || form.Deleted != 0 {
+		http.Error(w, "Form doesn't exist", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	origin := requestOrigin(req)
+	if !originAllowed(form, origin) {
+		http.Error(w, "Submissions from this site aren't allowed", http.StatusForbidden)
+		return
+	}
+
+	setCORSHeaders(w, form, origin)
+	h := w.Header()
+	h.Set("Access-Control-Allow-Methods", "POST")
+	if headers := req.Header.Get("Access-Control-Request-Headers"); headers != "" {
+		h.Set("Access-Control-Allow-Headers", headers)
+	}
+	h.Set("Access-Control-Max-Age", "86400")
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zenazn/goji/web"
+)
+
+const (
+	// powField holds a solved challenge: the challenge, a colon and the
+	// counter that solves it.
+	powField = "_formic_pow"
+	// powMaxAge is how long challenges can be solved and used for.
+	powMaxAge = time.Hour
+	// maxPowDifficulty keeps challenges solvable in a browser.
+	maxPowDifficulty = 24
+)
+
+// powChallenge asks for a counter whose SHA-256 hash, appended to the
+// challenge after a colon, starts with Difficulty zero bits.
+type powChallenge struct {
+	Issued     time.Time
+	Nonce      string
+	Difficulty int
+}
+
+func powMAC(id string, issued int64, nonce string, difficulty int) string {
+	mac := hmac.New(sha256.New, []byte(*sessionSecret))
+	fmt.Fprintf(mac, "pow.%s.%d.%s.%d", id, issued, nonce, difficulty)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (c powChallenge) sign(id string) string {
+	issued := c.Issued.Unix()
+	return fmt.Sprintf("%d.%s.%d.%s", issued, c.Nonce, c.Difficulty,
+		powMAC(id, issued, c.Nonce, c.Difficulty))
+}
+
+func parsePowChallenge(id, s string) (powChallenge, bool) {
+	var c powChallenge
+	parts := strings.Split(s, ".")
+	if len(parts) != 4 {
+		return c, false
+	}
+	issued, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return c, false
+	}
+	difficulty, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return c, false
+	}
+	if !hmac.Equal([]byte(parts[3]), []byte(powMAC(id, issued, parts[1], difficulty))) {
+		return c, false
+	}
+	return powChallenge{time.Unix(issued, 0), parts[1], difficulty}, true
+}
+
+func newPowChallenge(form Form, now time.Time) powChallenge {
+	return powChallenge{now, genID() + genID(), form.PowDifficulty}
+}
+
+func leadingZeroBits(b []byte) int {
+	n := 0
+	for _, c := range b {
+		if c == 0 {
+			n += 8
+			continue
+		}
+		for c&0x80 == 0 {
+			n++
+			c <<= 1
+		}
+		break
+	}
+	return n
+}
+
+// verifyProofOfWork checks the solved challenge submissions to forms with
+// a difficulty set need, and removes it from values. It returns the
+// challenge's nonce, which is empty for forms without proof of work.
+func verifyProofOfWork(form Form, values url.Values, now time.Time) (string, bool) {
+	if form.PowDifficulty <= 0 {
+		return "", true
+	}
+	solution := values.Get(powField)
+	delete(values, powField)
+
+	i := strings.LastIndex(solution, ":")
+	if i < 0 {
+		return "", false
+	}
+	c, ok := parsePowChallenge(form.ID, solution[:i])
+	if !ok || c.Difficulty < form.PowDifficulty ||
+		now.Sub(c.Issued) > powMaxAge || c.Issued.After(now.Add(time.Minute)) {
+		return "", false
+	}
+	sum := sha256.Sum256([]byte(solution))
+	if leadingZeroBits(sum[:]) < c.Difficulty {
+		return "", false
+	}
+	return c.Nonce, true
+}
+
+// usePowNonce records that the challenge with nonce was used, returning
+// false if it already was. It's tracked with the rate limiter, and only
+// called once an entry is accepted so rejected ones can be resubmitted.
+func usePowNonce(form Form, nonce string, now time.Time) (bool, error) {
+	if nonce == "" {
+		return true, nil
+	}
+	k := strings.Join([]string{"form", form.ID, "pow", nonce}, ":")
+	_, retry, err := limiter.Hit(k, 1, powMaxAge, now)
+	return retry == 0, err
+}
+
+func parseProofOfWork(form *Form, values url.Values) error {
+	form.PowDifficulty = 0
+	if v := strings.TrimSpace(values.Get("powDifficulty")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > maxPowDifficulty {
+			return fmt.Errorf("Proof of work difficulty must be between 0 and %d", maxPowDifficulty)
+		}
+		form.PowDifficulty = n
+	}
+	return nil
+}
+
+// showPowChallenge issues a challenge for scripts to solve themselves.
+func showPowChallenge(c web.C, w http.ResponseWriter, req *http.Request) {
+	form, ok := tokenForm(c, w, req)
+	if !ok {
+		return
+	}
+	challenge := newPowChallenge(form, time.Now())
+	w.Header().Set("Cache-Control", "no-store")
+	r.JSON(w, http.StatusOK, map[string]interface{}{
+		"field":      powField,
+		"challenge":  challenge.sign(form.ID),
+		"difficulty": challenge.Difficulty,
+	})
+}
+
+// powScript solves a challenge in the background and adds the solution to
+// the forms on a page posting to the form, holding back submissions until
+// it's found. It has to be loaded after the forms.
+const powScript = `(function() {
+  var challenge = %[3]s;
+  var difficulty = %[4]d;
+  var encoder = new TextEncoder();
+
+  function zeroBits(bytes) {
+    var n = 0;
+    for (var i = 0; i < bytes.length; i++) {
+      var b = bytes[i];
+      if (b === 0) {
+        n += 8;
+        continue;
+      }
+      while (!(b & 0x80)) {
+        n++;
+        b <<= 1;
+      }
+      break;
+    }
+    return n;
+  }
+
+  function solve(start) {
+    var hashes = [];
+    for (var i = 0; i < 256; i++) {
+      hashes.push(crypto.subtle.digest('SHA-256', encoder.encode(challenge + ':' + (start + i))));
+    }
+    return Promise.all(hashes).then(function(hashes) {
+      for (var i = 0; i < hashes.length; i++) {
+        if (zeroBits(new Uint8Array(hashes[i])) >= difficulty) {
+          return challenge + ':' + (start + i);
+        }
+      }
+      return solve(start + hashes.length);
+    });
+  }
+
+  var solution = window.formicSolution = solve(0);
+  var forms = document.querySelectorAll('form[action$="/s/%[1]s"]');
+  Array.prototype.forEach.call(forms, function(form) {
+    var input = document.createElement('input');
+    input.type = 'hidden';
+    input.name = '%[2]s';
+    form.appendChild(input);
+    form.addEventListener('submit', function(e) {
+      if (input.value) {
+        return;
+      }
+      e.preventDefault();
+      solution.then(function(value) {
+        input.value = value;
+        form.submit();
+      });
+    });
+    solution.then(function(value) {
+      input.value = value;
+    });
+  });
+})();
+`
+
+func showPowScript(c web.C, w http.ResponseWriter, req *http.Request) {
+	form, ok := tokenForm(c, w, req)
+	if !ok {
+		return
+	}
+	challenge := newPowChallenge(form, time.Now())
+	signed, _ := json.Marshal(challenge.sign(form.ID))
+	w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	fmt.Fprintf(w, powScript, form.ID, powField, signed, challenge.Difficulty)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// solvePow returns a solution to a new challenge for the form.
+func solvePow(form Form, issued time.Time) string {
+	challenge := newPowChallenge(form, issued).sign(form.ID)
+	for i := 0; ; i++ {
+		solution := challenge + ":" + strconv.Itoa(i)
+		if sum := sha256.Sum256([]byte(solution)); leadingZeroBits(sum[:]) >= form.PowDifficulty {
+			return solution
+		}
+	}
+}
+
+func TestLeadingZeroBits(t *testing.T) {
+	tests := []struct {
+		b    []byte
+		want int
+	}{
+		{[]byte{0x80}, 0},
+		{[]byte{0x01}, 7},
+		{[]byte{0x00, 0x00, 0x20}, 18},
+		{[]byte{0x00}, 8},
+	}
+	for _, test := range tests {
+		if got := leadingZeroBits(test.b); got != test.want {
+			t.Errorf("leadingZeroBits(%x) = %d; want %d", test.b, got, test.want)
+		}
+	}
+}
+
+func TestVerifyProofOfWork(t *testing.T) {
+	form := Form{ID: "form", PowDifficulty: 8}
+	now := time.Now()
+	solution := solvePow(form, now)
+	easier := solvePow(Form{ID: "form", PowDifficulty: 4}, now)
+	unsolved := newPowChallenge(form, now).sign(form.ID) + ":"
+	for i := 0; ; i++ {
+		if sum := sha256.Sum256([]byte(unsolved + strconv.Itoa(i))); leadingZeroBits(sum[:]) < 8 {
+			unsolved += strconv.Itoa(i)
+			break
+		}
+	}
+
+	tests := []struct {
+		name     string
+		form     Form
+		solution string
+		ok       bool
+	}{
+		{"solved", form, solution, true},
+		{"no proof of work", Form{ID: "form"}, "", true},
+		{"missing", form, "", false},
+		{"other form", Form{ID: "other", PowDifficulty: 8}, solution, false},
+		{"easier challenge", form, easier, false},
+		{"unsolved", form, unsolved, false},
+		{"expired", form, solvePow(form, now.Add(-powMaxAge-time.Minute)), false},
+	}
+	for _, test := range tests {
+		values := url.Values{powField: {test.solution}, "name": {"Mark"}}
+		nonce, ok := verifyProofOfWork(test.form, values, now)
+		if ok != test.ok {
+			t.Errorf("%s: got %v; want %v", test.name, ok, test.ok)
+		}
+		if ok && test.form.PowDifficulty > 0 && nonce == "" {
+			t.Errorf("%s: no nonce", test.name)
+		}
+		if _, found := values[powField]; found && test.form.PowDifficulty > 0 {
+			t.Errorf("%s: solution wasn't removed", test.name)
+		}
+	}
+}
+
+func TestSubmitEntryProofOfWork(t *testing.T) {
+	form := newTestForm(t, Form{PowDifficulty: 8, HoneypotField: "website"})
+	if err := store.SaveSchema(form.ID, []FieldSpec{{Name: "email", Type: FieldEmail, Required: true}}); err != nil {
+		t.Fatal(err)
+	}
+	solution := solvePow(form, time.Now())
+
+	if code, _ := submit(t, form, url.Values{"email": {"mark@example.com"}}); code != http.StatusBadRequest {
+		t.Errorf("submission without a solution got %d; want 400", code)
+	}
+	// Fixing the entry doesn't need a new solution.
+	if code, _ := submit(t, form, url.Values{powField: {solution}, "email": {"mark"}}); code != http.StatusBadRequest {
+		t.Errorf("invalid entry got %d; want 400", code)
+	}
+	if code, result := submit(t, form, url.Values{powField: {solution}, "email": {"mark@example.com"}}); code != http.StatusCreated {
+		t.Fatalf("resubmitted entry got %d %+v; want 201", code, result)
+	}
+	if code, _ := submit(t, form, url.Values{powField: {solution}, "email": {"mark@example.com"}}); code != http.StatusBadRequest {
+		t.Errorf("replayed solution got %d; want 400", code)
+	}
+
+	// Spam uses up solutions too.
+	spam := solvePow(form, time.Now())
+	for i, want := range []int{http.StatusCreated, http.StatusBadRequest} {
+		if code, _ := submit(t, form, url.Values{powField: {spam}, "name": {"Bot"}, "website": {"spam"}}); code != want {
+			t.Errorf("spam %d got %d; want %d", i, code, want)
+		}
+	}
+
+	if entries, _ := store.Entries(form.ID, EntryQuery{}); len(entries) != 1 {
+		t.Errorf("got %d entries; want 1", len(entries))
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimiter counts hits on keys over sliding windows.
+type RateLimiter interface {
+	// Hit records a hit on key unless limit hits were already recorded in
+	// the last window, returning how many there are now. If the hit was
+	// over the limit, retry is how long until the next one is allowed.
+	// Limits of 0 or less are unlimited.
+	Hit(key string, limit int, window time.Duration, now time.Time) (count int, retry time.Duration, err error)
+	// Count returns how many hits were recorded on key in the last window.
+	Count(key string, window time.Duration, now time.Time) (int, error)
+}
+
+func newRateLimiter(backend string) RateLimiter {
+	if backend == "redis" {
+		return newRedisRateLimiter(rp)
+	}
+	return newMemoryRateLimiter()
+}
+
+// memoryRateLimiter keeps hits in process memory, for stores that don't need
+// Redis. Limits aren't shared between processes.
+type memoryRateLimiter struct {
+	mu    sync.Mutex
+	hits  map[string][]time.Time
+	swept time.Time
+}
+
+func newMemoryRateLimiter() *memoryRateLimiter {
+	return &memoryRateLimiter{hits: make(map[string][]time.Time)}
+}
+
+// prune drops the hits on key older than window and returns the rest.
+func (l *memoryRateLimiter) prune(key string, window time.Duration, now time.Time) []time.Time {
+	hits := l.hits[key]
+	i := 0
+	for i < len(hits) && !hits[i].After(now.Add(-window)) {
+		i++
+	}
+	hits = hits[i:]
+	if len(hits) == 0 {
+		delete(l.hits, key)
+	} else {
+		l.hits[key] = hits
+	}
+	return hits
+}
+
+func (l *memoryRateLimiter) Hit(key string, limit int, window time.Duration, now time.Time) (int, time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Keys of clients that stopped posting would otherwise stay forever.
+	if now.Sub(l.swept) > window {
+		for k := range l.hits {
+			l.prune(k, window, now)
+		}
+		l.swept = now
+	}
+
+	hits := l.prune(key, window, now)
+	if limit > 0 && len(hits) >= limit {
+		return len(hits), hits[0].Add(window).Sub(now), nil
+	}
+	l.hits[key] = append(hits, now)
+	return len(hits) + 1, 0, nil
+}
+
+func (l *memoryRateLimiter) Count(key string, window time.Duration, now time.Time) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return len(l.prune(key, window, now)), nil
+}
+
+// formRateLimits returns the submissions allowed per client IP and for the
+// whole form in each rate limit window, using the global limits unless the
+// form sets its own.
+func formRateLimits(form Form) (perIP, perForm int) {
+	perIP, perForm = *rateLimitIP, *rateLimitForm
+	if form.RateLimitIP > 0 {
+		perIP = form.RateLimitIP
+	}
+	if form.RateLimitForm > 0 {
+		perForm = form.RateLimitForm
+	}
+	return perIP, perForm
+}
+
+func rateWindow() time.Duration {
+	return time.Duration(*rateLimitWindow) * time.Second
+}
+
+func rateKey(id string, parts ...string) string {
+	return strings.Join(append([]string{"form", id, "rate"}, parts...), ":")
+}
+
+// limitSubmission counts a submission to the form from ip, returning how
+// long the client has to wait if it's over either limit. Rejected
+// submissions are counted separately.
+func limitSubmission(form Form, ip string, now time.Time) (time.Duration, error) {
+	perIP, perForm := formRateLimits(form)
+	window := rateWindow()
+
+	_, retry, err := limiter.Hit(rateKey(form.ID, "ip", ip), perIP, window, now)
+	if err == nil && retry == 0 {
+		_, retry, err = limiter.Hit(rateKey(form.ID, "all"), perForm, window, now)
+	}
+	if err != nil || retry == 0 {
+		return 0, err
+	}
+	_, _, err = limiter.Hit(rateKey(form.ID, "rejected"), 0, window, now)
+	return retry, err
+}
+
+// RateCounts are the submissions to a form in the current rate limit
+// window, shown on the form page.
+type RateCounts struct {
+	Accepted int
+	Rejected int
+	PerIP    int
+	PerForm  int
+	Window   time.Duration
+}
+
+// Period describes the window, e.g. "hour" or "10 minutes".
+func (c RateCounts) Period() string {
+	for _, unit := range []struct {
+		d    time.Duration
+		name string
+	}{
+		{time.Hour, "hour"},
+		{time.Minute, "minute"},
+		{time.Second, "second"},
+	} {
+		if c.Window%unit.d != 0 {
+			continue
+		}
+		if n := int(c.Window / unit.d); n != 1 {
+			return fmt.Sprintf("%d %ss", n, unit.name)
+		}
+		return unit.name
+	}
+	return c.Window.String()
+}
+
+func formRateCounts(form Form, now time.Time) (RateCounts, error) {
+	counts := RateCounts{Window: rateWindow()}
+	counts.PerIP, counts.PerForm = formRateLimits(form)
+
+	var err error
+	if counts.Accepted, err = limiter.Count(rateKey(form.ID, "all"), counts.Window, now); err != nil {
+		return counts, err
+	}
+	counts.Rejected, err = limiter.Count(rateKey(form.ID, "rejected"), counts.Window, now)
+	return counts, err
+}
+
+func parseRateLimits(form *Form, values url.Values) error {
+	for _, limit := range []struct {
+		name  string
+		label string
+		value *int
+	}{
+		{"rateLimitIP", "Submissions per IP", &form.RateLimitIP},
+		{"rateLimitForm", "Submissions per form", &form.RateLimitForm},
+	} {
+		*limit.value = 0
+		if v := strings.TrimSpace(values.Get(limit.name)); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return fmt.Errorf("%s must be a non-negative number", limit.label)
+			}
+			*limit.value = n
+		}
+	}
+	return nil
+}
+
+var trustedProxyNets []*net.IPNet
+
+// parseTrustedProxies reads a comma separated list of proxy addresses or
+// CIDR ranges.
+func parseTrustedProxies(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, proxy := range splitList(s) {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", proxy)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", proxy)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func trustedProxy(ip net.IP) bool {
+	for _, n := range trustedProxyNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address a request came from. Requests from trusted
+// proxies are traced back through X-Forwarded-For to the first address that
+// isn't one.
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !trustedProxy(ip) {
+		return host
+	}
+	hops := strings.Split(strings.Join(req.Header["X-Forwarded-For"], ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !trustedProxy(ip) {
+			break
+		}
+	}
+	return ip.String()
+}
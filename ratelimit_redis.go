@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// redisRateLimiter keeps the times of hits on each key in a sorted set, so
+// limits are shared by every process using the same Redis.
+type redisRateLimiter struct {
+	pool *redis.Pool
+}
+
+func newRedisRateLimiter(pool *redis.Pool) *redisRateLimiter {
+	return &redisRateLimiter{pool: pool}
+}
+
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func (l *redisRateLimiter) Hit(k string, limit int, window time.Duration, now time.Time) (int, time.Duration, error) {
+	rc := l.pool.Get()
+	defer rc.Close()
+
+	k = key(k)
+	// Members only need to be unique, hits are ordered by their scores.
+	member := fmt.Sprintf("%d-%s", now.UnixNano(), genID())
+
+	rc.Send("MULTI")
+	rc.Send("ZREMRANGEBYSCORE", k, "-inf", millis(now.Add(-window)))
+	rc.Send("ZADD", k, millis(now), member)
+	rc.Send("ZCARD", k)
+	rc.Send("ZRANGE", k, 0, 0, "WITHSCORES")
+	rc.Send("PEXPIRE", k, int64(window/time.Millisecond))
+	replies, err := redis.Values(rc.Do("EXEC"))
+	if err != nil {
+		return 0, 0, err
+	}
+	count, err := redis.Int(replies[2], nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	if limit <= 0 || count <= limit {
+		return count, 0, nil
+	}
+
+	// Hits over the limit don't count towards it.
+	if _, err := rc.Do("ZREM", k, member); err != nil {
+		return 0, 0, err
+	}
+	oldest, err := redis.Values(replies[3], nil)
+	if err != nil || len(oldest) < 2 {
+		return count - 1, window, err
+	}
+	first, err := redis.Int64(oldest[1], nil)
+	if err != nil {
+		return count - 1, window, err
+	}
+	return count - 1, time.Duration(first+int64(window/time.Millisecond)-millis(now)) * time.Millisecond, nil
+}
+
+func (l *redisRateLimiter) Count(k string, window time.Duration, now time.Time) (int, error) {
+	rc := l.pool.Get()
+	defer rc.Close()
+
+	return redis.Int(rc.Do("ZCOUNT", key(k), "("+fmt.Sprint(millis(now.Add(-window))), "+inf"))
+}
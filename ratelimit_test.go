@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestMemoryRateLimiter(t *testing.T) {
+	l := newMemoryRateLimiter()
+	now := time.Unix(1000, 0)
+	window := time.Minute
+
+	for i := 1; i <= 2; i++ {
+		count, retry, err := l.Hit("k", 2, window, now.Add(time.Duration(i)*time.Second))
+		if err != nil || count != i || retry != 0 {
+			t.Fatalf("hit %d = %d, %v, %v; want %d, 0", i, count, retry, err, i)
+		}
+	}
+	count, retry, _ := l.Hit("k", 2, window, now.Add(10*time.Second))
+	if count != 2 || retry != 51*time.Second {
+		t.Errorf("hit over the limit = %d, %v; want 2, 51s", count, retry)
+	}
+	if count, _ := l.Count("k", window, now.Add(10*time.Second)); count != 2 {
+		t.Errorf("Count = %d; want rejected hits not to count", count)
+	}
+
+	// The first hit leaves the window, making room for another.
+	if _, retry, _ := l.Hit("k", 2, window, now.Add(61*time.Second)); retry != 0 {
+		t.Errorf("hit after the window retry = %v; want 0", retry)
+	}
+	if _, retry, _ := l.Hit("other", 0, window, now); retry != 0 {
+		t.Errorf("unlimited hit retry = %v; want 0", retry)
+	}
+
+	l.Hit("stale", 1, window, now)
+	l.Hit("k", 2, window, now.Add(3*window))
+	if _, ok := l.hits["stale"]; ok {
+		t.Error("keys without hits in the window weren't swept")
+	}
+}
+
+func TestFormRateLimits(t *testing.T) {
+	defer func(ip, form int) { *rateLimitIP, *rateLimitForm = ip, form }(*rateLimitIP, *rateLimitForm)
+	*rateLimitIP, *rateLimitForm = 5, 50
+
+	if ip, all := formRateLimits(Form{}); ip != 5 || all != 50 {
+		t.Errorf("global limits = %d, %d; want 5, 50", ip, all)
+	}
+	if ip, all := formRateLimits(Form{RateLimitIP: 1}); ip != 1 || all != 50 {
+		t.Errorf("form limits = %d, %d; want 1, 50", ip, all)
+	}
+}
+
+func TestSubmitEntryRateLimit(t *testing.T) {
+	form := newTestForm(t, Form{RateLimitIP: 2})
+	entry := url.Values{"name": {"Mark"}}
+
+	for i := 0; i < 2; i++ {
+		if code, result := submit(t, form, entry); code != http.StatusCreated {
+			t.Fatalf("submission %d: %d %+v", i, code, result)
+		}
+	}
+	if code, _ := submit(t, form, entry); code != http.StatusTooManyRequests {
+		t.Errorf("third submission from an IP got %d; want 429", code)
+	}
+
+	counts, err := formRateCounts(form, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts.Accepted != 2 || counts.Rejected != 1 {
+		t.Errorf("counts = %+v; want 2 accepted and 1 rejected", counts)
+	}
+}
+
+func TestParseRateLimits(t *testing.T) {
+	var form Form
+	if err := parseRateLimits(&form, url.Values{"rateLimitIP": {"3"}}); err != nil || form.RateLimitIP != 3 || form.RateLimitForm != 0 {
+		t.Errorf("got %+v, %v", form, err)
+	}
+	if err := parseRateLimits(&form, url.Values{"rateLimitForm": {"-1"}}); err == nil {
+		t.Error("negative limit was accepted")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	defer func(nets []*net.IPNet) { trustedProxyNets = nets }(trustedProxyNets)
+	var err error
+	if trustedProxyNets, err = parseTrustedProxies("10.0.0.0/8, 192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		remote    string
+		forwarded string
+		want      string
+	}{
+		{"198.51.100.1:1234", "203.0.113.1", "198.51.100.1"},
+		{"192.0.2.1:1234", "203.0.113.1, 10.0.0.2", "203.0.113.1"},
+		{"10.0.0.1:1234", "203.0.113.1, 198.51.100.7, 10.0.0.2", "198.51.100.7"},
+		{"10.0.0.1:1234", "garbage", "10.0.0.1"},
+	}
+	for _, test := range tests {
+		req := &http.Request{RemoteAddr: test.remote, Header: http.Header{"X-Forwarded-For": {test.forwarded}}}
+		if got := clientIP(req); got != test.want {
+			t.Errorf("clientIP(%s, %q) = %s; want %s", test.remote, test.forwarded, got, test.want)
+		}
+	}
+}
+
+func TestLimitSubmissionPerForm(t *testing.T) {
+	form := newTestForm(t, Form{RateLimitForm: 2})
+	now := time.Now()
+
+	for i, ip := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"} {
+		retry, err := limitSubmission(form, ip, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if over := retry > 0; over != (i == 2) {
+			t.Errorf("submission %d from %s retry = %v", i, ip, retry)
+		}
+	}
+}
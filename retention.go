@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	RetentionDelete    = "delete"
+	RetentionAnonymize = "anonymize"
+)
+
+func parseRetention(form *Form, values url.Values) error {
+	days := 0
+	if v := strings.TrimSpace(values.Get("retentionDays")); v != "" {
+		var err error
+		days, err = strconv.Atoi(v)
+		if err != nil || days < 0 {
+			return errors.New("Retention days must be a non-negative number")
+		}
+	}
+
+	action := values.Get("retentionAction")
+	if action != RetentionDelete && action != RetentionAnonymize {
+		return errors.New("Retention action must be delete or anonymize")
+	}
+
+	fields := strings.Join(splitList(values.Get("retentionFields")), ", ")
+	if days > 0 && action == RetentionAnonymize && fields == "" {
+		return errors.New("Choose which fields to anonymize")
+	}
+
+	// Entries anonymized so far only had the old fields blanked.
+	if fields != form.RetentionFields {
+		form.AnonymizedUntil = 0
+	}
+	form.RetentionDays = days
+	form.RetentionAction = action
+	form.RetentionFields = fields
+	return nil
+}
+
+// retentionCutoff returns the submission time at or before which entries
+// fall under the form's retention policy.
+func retentionCutoff(form Form, now time.Time) int64 {
+	return now.AddDate(0, 0, -form.RetentionDays).Unix()
+}
+
+// anonymizeSince returns the submission time anonymizing the form's entries
+// picks up from, or 0 for all of them if it hasn't run with its fields yet.
+func anonymizeSince(form Form) int64 {
+	if form.AnonymizedUntil == 0 {
+		return 0
+	}
+	return form.AnonymizedUntil + 1
+}
+
+// nextPurge returns when the oldest entry not yet handled by the form's
+// retention policy will be, or 0 if there's no such entry.
+func nextPurge(form Form) (int64, error) {
+	if form.RetentionDays <= 0 {
+		return 0, nil
+	}
+	q := EntryQuery{Oldest: true, Limit: 1}
+	if form.RetentionAction == RetentionAnonymize {
+		q.Since = anonymizeSince(form)
+	}
+	entries, err := store.Entries(form.ID, q)
+	if err != nil || len(entries) == 0 {
+		return 0, err
+	}
+	submitted := time.Unix(entries[0].Submitted, 0)
+	return submitted.AddDate(0, 0, form.RetentionDays).Unix(), nil
+}
+
+// retentionField reports whether a field is one of fields or nested in one,
+// so listing address also clears address.city.
+func retentionField(fields []string, field string) bool {
+	for _, f := range fields {
+		if field == f || strings.HasPrefix(field, f+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func applyRetention(form Form, now time.Time) error {
+	cutoff := retentionCutoff(form, now)
+
+	switch form.RetentionAction {
+	case RetentionDelete:
+		entries, err := store.Entries(form.ID, EntryQuery{Until: cutoff})
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := removeEntry(form.ID, entry); err != nil {
+				return err
+			}
+		}
+		if len(entries) > 0 {
+			log.Printf("Deleted %d entries of form %s", len(entries), form.ID)
+		}
+
+	case RetentionAnonymize:
+		entries, err := store.Entries(form.ID, EntryQuery{
+			Since: anonymizeSince(form),
+			Until: cutoff,
+		})
+		if err != nil {
+			return err
+		}
+		fields := splitList(form.RetentionFields)
+		for _, entry := range entries {
+			changed := false
+			attachments := make(map[string][]Attachment)
+			for field, value := range entry.Values {
+				if !retentionField(fields, field) || value == "" {
+					continue
+				}
+				attachments[field] = entryAttachments(value)
+				entry.Values[field] = ""
+				changed = true
+			}
+			if !changed {
+				continue
+			}
+			if err := store.UpdateEntry(form.ID, entry); err != nil {
+				return err
+			}
+			deleteAttachments(form.ID, entry.ID, attachments)
+		}
+		if len(entries) > 0 {
+			log.Printf("Anonymized %d entries of form %s", len(entries), form.ID)
+		}
+
+		// Reload the form so changes made in the meantime aren't lost and
+		// only move on if the policy is still the same.
+		latest, err := store.Form(form.ID)
+		if err != nil {
+			return err
+		}
+		if latest.RetentionFields != form.RetentionFields ||
+			latest.AnonymizedUntil != form.AnonymizedUntil {
+			return nil
+		}
+		latest.AnonymizedUntil = cutoff
+		return store.SaveForm(latest)
+	}
+
+	return nil
+}
+
+// enforceRetention applies the retention policy of every form that has one.
+func enforceRetention() error {
+	ids, err := store.Forms()
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	for _, id := range ids {
+		form, err := store.Form(id)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if form.Deleted != 0 || form.RetentionDays <= 0 {
+			continue
+		}
+		if err = applyRetention(form, now); err != nil {
+			log.Printf("Error applying retention policy of form %s: %s", id, err.Error())
+		}
+	}
+	return nil
+}
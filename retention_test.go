@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func addTestEntries(t *testing.T, id string, entries ...Entry) {
+	for _, entry := range entries {
+		if err := store.AddEntry(id, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestApplyRetentionDelete(t *testing.T) {
+	store = newMemoryStore()
+	now := time.Unix(100*86400, 0)
+	form := Form{ID: "form", RetentionDays: 30, RetentionAction: RetentionDelete}
+	addTestEntries(t, form.ID,
+		Entry{"old", -1000, map[string]string{"name": "Imported"}},
+		Entry{"due", 70 * 86400, map[string]string{"name": "Mark"}},
+		Entry{"new", 70*86400 + 1, map[string]string{"name": "Steve"}},
+	)
+
+	if err := applyRetention(form, now); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := store.Entries(form.ID, EntryQuery{})
+	if ids := testIDs(entries); !reflect.DeepEqual(ids, []string{"new"}) {
+		t.Errorf("entries = %v; want [new]", ids)
+	}
+	if next, err := nextPurge(form); err != nil || next != 100*86400+1 {
+		t.Errorf("nextPurge = %d, %v; want %d", next, err, 100*86400+1)
+	}
+}
+
+func TestApplyRetentionAnonymize(t *testing.T) {
+	store = newMemoryStore()
+	form := Form{ID: "form", RetentionDays: 30, RetentionAction: RetentionAnonymize}
+	if err := parseRetention(&form, url.Values{
+		"retentionDays":   {"30"},
+		"retentionAction": {RetentionAnonymize},
+		"retentionFields": {"email, address"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveForm(form); err != nil {
+		t.Fatal(err)
+	}
+	values := func() map[string]string {
+		return map[string]string{"name": "Mark", "email": "mark@example.com", "address.city": "Manila"}
+	}
+	anonymized := map[string]string{"name": "Mark", "email": "", "address.city": ""}
+	addTestEntries(t, form.ID,
+		Entry{"imported", -1000, values()},
+		Entry{"epoch", 0, values()},
+		Entry{"due", 70 * 86400, values()},
+		Entry{"new", 70*86400 + 1, values()},
+	)
+
+	now := time.Unix(100*86400, 0)
+	if err := applyRetention(form, now); err != nil {
+		t.Fatal(err)
+	}
+	for _, eid := range []string{"imported", "epoch", "due"} {
+		if entry, _ := store.Entry(form.ID, eid); !reflect.DeepEqual(entry.Values, anonymized) {
+			t.Errorf("%s = %v; want %v", eid, entry.Values, anonymized)
+		}
+	}
+	if entry, _ := store.Entry(form.ID, "new"); !reflect.DeepEqual(entry.Values, values()) {
+		t.Errorf("new = %v; want it untouched", entry.Values)
+	}
+
+	// Later runs pick up where the last one ended.
+	form, _ = store.Form(form.ID)
+	if form.AnonymizedUntil != 70*86400 {
+		t.Fatalf("AnonymizedUntil = %d; want %d", form.AnonymizedUntil, 70*86400)
+	}
+	if next, err := nextPurge(form); err != nil || next != 100*86400+1 {
+		t.Errorf("nextPurge = %d, %v; want %d", next, err, 100*86400+1)
+	}
+	if err := applyRetention(form, now.Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if entry, _ := store.Entry(form.ID, "new"); !reflect.DeepEqual(entry.Values, anonymized) {
+		t.Errorf("new = %v; want %v", entry.Values, anonymized)
+	}
+
+	// Changing the fields starts over.
+	if err := parseRetention(&form, url.Values{
+		"retentionDays":   {"30"},
+		"retentionAction": {RetentionAnonymize},
+		"retentionFields": {"name"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if form.AnonymizedUntil != 0 {
+		t.Errorf("AnonymizedUntil = %d; want 0", form.AnonymizedUntil)
+	}
+}
+
+func TestParseRetention(t *testing.T) {
+	tests := []struct {
+		values url.Values
+		ok     bool
+	}{
+		{url.Values{"retentionDays": {""}, "retentionAction": {RetentionDelete}}, true},
+		{url.Values{"retentionDays": {"0"}, "retentionAction": {RetentionDelete}}, true},
+		{url.Values{"retentionDays": {"-1"}, "retentionAction": {RetentionDelete}}, false},
+		{url.Values{"retentionDays": {"30"}, "retentionAction": {"shred"}}, false},
+		{url.Values{"retentionDays": {"30"}, "retentionAction": {RetentionAnonymize}}, false},
+	}
+	for _, test := range tests {
+		var form Form
+		if err := parseRetention(&form, test.values); (err == nil) != test.ok {
+			t.Errorf("parseRetention(%v): %v", test.values, err)
+		}
+	}
+}
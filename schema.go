@@ -0,0 +1,291 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/sessions"
+	"github.com/zenazn/goji/web"
+)
+
+const (
+	FieldText   = "text"
+	FieldEmail  = "email"
+	FieldNumber = "number"
+	FieldURL    = "url"
+	FieldDate   = "date"
+	FieldFile   = "file"
+)
+
+var fieldTypes = []string{FieldText, FieldEmail, FieldNumber, FieldURL, FieldDate, FieldFile}
+
+// FieldSpec describes a field entries of a form can have.
+type FieldSpec struct {
+	Name      string
+	Type      string
+	Required  bool
+	MaxLength int
+	// Pattern is a regular expression the whole value must match.
+	Pattern string
+	// Options, if any, are the only values allowed.
+	Options []string
+}
+
+func (spec FieldSpec) OptionList() string {
+	return strings.Join(spec.Options, ", ")
+}
+
+// check returns what's wrong with value, or "" if it's valid.
+func (spec FieldSpec) check(value string) string {
+	if strings.TrimSpace(value) == "" {
+		if spec.Required {
+			return "is required"
+		}
+		return ""
+	}
+
+	// Uploads are limited by the form's upload settings instead.
+	if spec.Type == FieldFile {
+		if parseAttachment(value) == nil {
+			return "must be a file"
+		}
+		return ""
+	}
+	if parseAttachment(value) != nil {
+		return "can't be a file"
+	}
+
+	if spec.MaxLength > 0 && utf8.RuneCountInString(value) > spec.MaxLength {
+		return fmt.Sprintf("must be at most %d characters", spec.MaxLength)
+	}
+
+	switch spec.Type {
+	case FieldEmail:
+		if a, err := mail.ParseAddress(value); err != nil || a.Address != value {
+			return "must be an email address"
+		}
+	case FieldNumber:
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return "must be a number"
+		}
+	case FieldURL:
+		u, err := url.Parse(value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return "must be a URL"
+		}
+	case FieldDate:
+		if _, err := time.Parse(dateLayout, value); err != nil {
+			return "must be a date (YYYY-MM-DD)"
+		}
+	}
+
+	if spec.Pattern != "" {
+		re, err := regexp.Compile("^(?:" + spec.Pattern + ")$")
+		if err != nil || !re.MatchString(value) {
+			return "isn't in the expected format"
+		}
+	}
+
+	if len(spec.Options) > 0 {
+		for _, option := range spec.Options {
+			if value == option {
+				return ""
+			}
+		}
+		return "must be one of " + spec.OptionList()
+	}
+
+	return ""
+}
+
+// FieldErrors maps field names to what's wrong with their values.
+type FieldErrors map[string]string
+
+func (e FieldErrors) Fields() []string {
+	fields := make([]string, 0, len(e))
+	for field := range e {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func (e FieldErrors) Error() string {
+	var msgs []string
+	for _, field := range e.Fields() {
+		msgs = append(msgs, field+" "+e[field])
+	}
+	return strings.Join(msgs, ", ")
+}
+
+// checkAll checks each value of a field posted more than once. Empty values
+// are skipped unless there are no others.
+func (spec FieldSpec) checkAll(values []string) string {
+	checked := false
+	for _, value := range values {
+		if strings.TrimSpace(value) == "" {
+			continue
+		}
+		if msg := spec.check(value); msg != "" {
+			return msg
+		}
+		checked = true
+	}
+	if !checked {
+		return spec.check("")
+	}
+	return ""
+}
+
+// validateEntry checks submitted values against the form's schema. Forms
+// without one take anything.
+func validateEntry(schema []FieldSpec, values url.Values) FieldErrors {
+	if len(schema) == 0 {
+		return nil
+	}
+
+	errs := make(FieldErrors)
+	known := make(map[string]bool, len(schema))
+	for _, spec := range schema {
+		known[spec.Name] = true
+		if msg := spec.checkAll(values[spec.Name]); msg != "" {
+			errs[spec.Name] = msg
+		}
+	}
+	for field := range values {
+		if !known[field] {
+			errs[field] = "isn't a field of this form"
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// parseSchema reads the schema editor, whose inputs are repeated once per
+// field. Required checkboxes hold the index of their field and rows without
+// a name are dropped.
+func parseSchema(values url.Values) ([]FieldSpec, error) {
+	names := values["name"]
+	types := values["type"]
+	maxLengths := values["maxLength"]
+	patterns := values["pattern"]
+	options := values["options"]
+	if len(types) != len(names) || len(maxLengths) != len(names) ||
+		len(patterns) != len(names) || len(options) != len(names) {
+		return nil, errors.New("Incomplete fields")
+	}
+
+	required := make(map[string]bool)
+	for _, i := range values["required"] {
+		required[i] = true
+	}
+
+	var schema []FieldSpec
+	seen := make(map[string]bool)
+	for i, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("There's more than one %s field", name)
+		}
+		seen[name] = true
+
+		spec := FieldSpec{
+			Name:     name,
+			Type:     types[i],
+			Required: required[strconv.Itoa(i)],
+			Pattern:  strings.TrimSpace(patterns[i]),
+			Options:  splitList(options[i]),
+		}
+
+		valid := false
+		for _, t := range fieldTypes {
+			valid = valid || spec.Type == t
+		}
+		if !valid {
+			return nil, fmt.Errorf("Unknown type for %s", name)
+		}
+
+		if v := strings.TrimSpace(maxLengths[i]); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("Max length of %s must be a non-negative number", name)
+			}
+			spec.MaxLength = n
+		}
+
+		if spec.Pattern != "" {
+			if _, err := regexp.Compile(spec.Pattern); err != nil {
+				return nil, fmt.Errorf("Invalid pattern for %s", name)
+			}
+		}
+
+		schema = append(schema, spec)
+	}
+	return schema, nil
+}
+
+// schemaBlankRows is how many empty rows the schema editor has for adding
+// fields.
+const schemaBlankRows = 3
+
+func showSchema(c web.C, w http.ResponseWriter, req *http.Request) {
+	form := c.Env["form"].(Form)
+
+	schema, err := store.Schema(form.ID)
+	if err != nil {
+		http.Error(w, "Error showing fields: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rows := append(schema, make([]FieldSpec, schemaBlankRows)...)
+
+	r.HTML(w, http.StatusOK, "schema", map[string]interface{}{
+		"Form":       form,
+		"Rows":       rows,
+		"FieldTypes": fieldTypes,
+		"Messages":   getMessages(c, w, req),
+	})
+}
+
+func updateSchema(c web.C, w http.ResponseWriter, req *http.Request) {
+	var err error
+
+	session := c.Env["session"].(*sessions.Session)
+	form := c.Env["form"].(Form)
+
+	defer func() {
+		if err != nil {
+			session.AddFlash(err.Error(), "warning")
+		} else {
+			session.AddFlash("Fields updated", "success")
+		}
+		session.Save(req, w)
+		url := fmt.Sprintf("/dashboard/%s/schema", form.ID)
+		http.Redirect(w, req, url, http.StatusFound)
+	}()
+
+	if err = req.ParseForm(); err != nil {
+		return
+	}
+
+	schema, err := parseSchema(req.PostForm)
+	if err != nil {
+		return
+	}
+
+	err = store.SaveSchema(form.ID, schema)
+}
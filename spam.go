@@ -0,0 +1,271 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/zenazn/goji/web"
+)
+
+const (
+	// spamTokenField holds the token issued by /s/:id/token when the form
+	// was loaded, so submissions made too quickly can be told apart.
+	spamTokenField = "_formic_token"
+	// spamTokenMaxAge is how long tokens are accepted, so bots can't keep
+	// reusing one.
+	spamTokenMaxAge = 24 * time.Hour
+)
+
+func parseSpamFilter(form *Form, values url.Values) error {
+	form.HoneypotField = strings.TrimSpace(values.Get("honeypotField"))
+	if form.HoneypotField == spamTokenField {
+		return fmt.Errorf("%s is used for the time trap", spamTokenField)
+	}
+	form.MinSubmitSeconds = 0
+	if v := strings.TrimSpace(values.Get("minSubmitSeconds")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return errors.New("Minimum time to submit must be a non-negative number")
+		}
+		form.MinSubmitSeconds = n
+	}
+	return nil
+}
+
+func spamTokenMAC(id string, issued int64) string {
+	mac := hmac.New(sha256.New, []byte(*sessionSecret))
+	fmt.Fprintf(mac, "%s.%d", id, issued)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// spamToken signs the time a form was loaded.
+func spamToken(id string, issued time.Time) string {
+	t := issued.Unix()
+	return fmt.Sprintf("%d.%s", t, spamTokenMAC(id, t))
+}
+
+// parseSpamToken returns the time a token for the form was issued.
+func parseSpamToken(id, token string) (time.Time, bool) {
+	i := strings.Index(token, ".")
+	if i < 0 {
+		return time.Time{}, false
+	}
+	t, err := strconv.ParseInt(token[:i], 10, 64)
+	if err != nil || !hmac.Equal([]byte(token[i+1:]), []byte(spamTokenMAC(id, t))) {
+		return time.Time{}, false
+	}
+	return time.Unix(t, 0), true
+}
+
+// spamReason returns why a submission looks like spam, or "" if it
+// doesn't. The honeypot is a field people can't see and bots fill in.
+func spamReason(form Form, values url.Values, now time.Time) string {
+	if form.HoneypotField != "" {
+		for _, v := range values[form.HoneypotField] {
+			if strings.TrimSpace(v) != "" {
+				return "honeypot field filled in"
+			}
+		}
+	}
+	if form.MinSubmitSeconds > 0 {
+		issued, ok := parseSpamToken(form.ID, values.Get(spamTokenField))
+		switch {
+		case !ok:
+			return "missing or invalid token"
+		case now.Sub(issued) < time.Duration(form.MinSubmitSeconds)*time.Second:
+			return "submitted too quickly"
+		case now.Sub(issued) > spamTokenMaxAge:
+			return "token expired"
+		}
+	}
+	return ""
+}
+
+// showSpamToken issues a token for scripts to post along with an entry.
+func showSpamToken(c web.C, w http.ResponseWriter, req *http.Request) {
+	form, ok := tokenForm(c, w, req)
+	if !ok {
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	r.JSON(w, http.StatusOK, map[string]string{
+		"field": spamTokenField,
+		"token": spamToken(form.ID, time.Now()),
+	})
+}
+
+// spamTokenScript adds a token to the forms on a page posting to the form.
+// It has to be loaded after them.
+const spamTokenScript = `(function() {
+  var forms = document.querySelectorAll('form[action$="/s/%[1]s"]');
+  Array.prototype.forEach.call(forms, function(form) {
+    var input = form.querySelector('input[name="%[2]s"]');
+    if (!input) {
+      input = document.createElement('input');
+      input.type = 'hidden';
+      input.name = '%[2]s';
+      form.appendChild(input);
+    }
+    input.value = %[3]s;
+  });
+})();
+`
+
+func showSpamTokenScript(c web.C, w http.ResponseWriter, req *http.Request) {
+	form, ok := tokenForm(c, w, req)
+	if !ok {
+		return
+	}
+	token, _ := json.Marshal(spamToken(form.ID, time.Now()))
+	w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	fmt.Fprintf(w, spamTokenScript, form.ID, spamTokenField, token)
+}
+
+// tokenForm gets the form tokens are requested for, checking it takes
+// entries from the requesting site.
+func tokenForm(c web.C, w http.ResponseWriter, req *http.Request) (Form, bool) {
+	form, err := store.Form(c.URLParams["id"])
+	if err == ErrNotFound || form.Deleted != 0 {
+		http.Error(w, "Form doesn't exist", http.StatusNotFound)
+		return form, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return form, false
+	}
+	origin := requestOrigin(req)
+	if !originAllowed(form, origin) {
+		http.Error(w, "Submissions from this site aren't allowed", http.StatusForbidden)
+		return form, false
+	}
+	setCORSHeaders(w, form, origin)
+	return form, true
+}
+
+func showSpam(c web.C, w http.ResponseWriter, req *http.Request) {
+	form := c.Env["form"].(Form)
+
+	page, err := pageEntries(store.Spam, form.ID, req.URL.Query())
+	if _, ok := err.(queryError); ok {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error showing spam: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Spam doesn't register fields, so they're taken from what's shown.
+	known := make(map[string]bool)
+	var fields []string
+	for _, entry := range page.Entries {
+		for field := range entry.Values {
+			if !known[field] {
+				known[field] = true
+				fields = append(fields, field)
+			}
+		}
+	}
+	sort.Strings(fields)
+
+	r.HTML(w, http.StatusOK, "spam", map[string]interface{}{
+		"Form":          form,
+		"Role":          c.Env["role"],
+		"Fields":        fields,
+		"Entries":       page.Entries,
+		"Newer":         page.Newer,
+		"Older":         page.Older,
+		"RetentionDays": *trashRetentionDays,
+		"Messages":      getMessages(c, w, req),
+	})
+}
+
+// restoreSpam moves a submission caught by the spam filter to the form's
+// entries.
+func restoreSpam(c web.C, w http.ResponseWriter, req *http.Request) {
+	var err error
+
+	session := c.Env["session"].(*sessions.Session)
+	form := c.Env["form"].(Form)
+
+	defer func() {
+		if err != nil {
+			session.AddFlash("Error moving entry: "+err.Error(), "warning")
+		} else {
+			session.AddFlash("Entry moved out of spam", "success")
+		}
+		session.Save(req, w)
+		http.Redirect(w, req, fmt.Sprintf("/dashboard/%s/spam", form.ID), http.StatusFound)
+	}()
+
+	entry, err := store.DeleteSpam(form.ID, c.URLParams["eid"])
+	if err != nil {
+		return
+	}
+	// Spam caught before values were checked for reserved prefixes could
+	// still have them.
+	values := url.Values{}
+	for field, value := range entry.Values {
+		values[field] = parseList(value)
+	}
+	if errs := checkReserved(nil, values); errs != nil {
+		err = errs
+	} else {
+		err = store.AddEntry(form.ID, entry)
+	}
+	if err != nil {
+		if err := store.AddSpam(form.ID, entry); err != nil {
+			log.Printf("Error putting back spam %s: %s", entry.ID, err.Error())
+		}
+	}
+}
+
+func deleteSpam(c web.C, w http.ResponseWriter, req *http.Request) {
+	session := c.Env["session"].(*sessions.Session)
+	form := c.Env["form"].(Form)
+
+	_, err := store.DeleteSpam(form.ID, c.URLParams["eid"])
+	if err != nil && err != ErrNotFound {
+		session.AddFlash(err.Error(), "warning")
+	} else {
+		session.AddFlash("Spam deleted", "success")
+	}
+	session.Save(req, w)
+}
+
+// purgeSpam deletes spam kept longer than the trash.
+func purgeSpam() error {
+	before := time.Now().UTC().AddDate(0, 0, -*trashRetentionDays).Unix()
+	ids, err := store.Forms()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		spam, err := store.Spam(id, EntryQuery{Until: before})
+		if err != nil {
+			return err
+		}
+		for _, entry := range spam {
+			if _, err := store.DeleteSpam(id, entry.ID); err != nil && err != ErrNotFound {
+				return err
+			}
+		}
+		if len(spam) > 0 {
+			log.Printf("Purged %d spam submissions of form %s", len(spam), id)
+		}
+	}
+	return nil
+}
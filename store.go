@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var ErrNotFound = errors.New("not found")
+
+type Collaborator struct {
+	Email string
+	Role  string
+}
+
+type Entry struct {
+	ID        string
+	Submitted int64
+	Values    map[string]string
+}
+
+// EntryCursor is the position of an entry in a listing.
+type EntryCursor struct {
+	Submitted int64
+	ID        string
+}
+
+func (c EntryCursor) String() string {
+	return fmt.Sprintf("%d-%s", c.Submitted, c.ID)
+}
+
+func parseEntryCursor(s string) (EntryCursor, error) {
+	var c EntryCursor
+	i := strings.Index(s, "-")
+	if i < 0 {
+		return c, fmt.Errorf("invalid cursor %q", s)
+	}
+	submitted, err := strconv.ParseInt(s[:i], 10, 64)
+	if err != nil || i == len(s)-1 {
+		return c, fmt.Errorf("invalid cursor %q", s)
+	}
+	c.Submitted, c.ID = submitted, s[i+1:]
+	return c, nil
+}
+
+func (e Entry) Cursor() EntryCursor {
+	return EntryCursor{e.Submitted, e.ID}
+}
+
+// EntryQuery selects entries by submission time and values. Zero values
+// leave Since, Until and Limit unbounded.
+type EntryQuery struct {
+	Since  int64
+	Until  int64
+	Limit  int
+	Oldest bool // oldest first instead of newest first
+	// After skips entries up to and including the cursor, in the order
+	// they're listed.
+	After EntryCursor
+	// Filters maps field names to substrings their values must contain,
+	// ignoring case.
+	Filters map[string]string
+}
+
+func (q EntryQuery) inRange(entry Entry) bool {
+	return (q.Since == 0 || entry.Submitted >= q.Since) &&
+		(q.Until == 0 || entry.Submitted <= q.Until)
+}
+
+func (q EntryQuery) pastCursor(entry Entry) bool {
+	if q.After.ID == "" {
+		return true
+	}
+	if q.Oldest {
+		return entry.Submitted > q.After.Submitted ||
+			entry.Submitted == q.After.Submitted && entry.ID > q.After.ID
+	}
+	return entry.Submitted < q.After.Submitted ||
+		entry.Submitted == q.After.Submitted && entry.ID < q.After.ID
+}
+
+func (q EntryQuery) matches(entry Entry) bool {
+	if !q.inRange(entry) || !q.pastCursor(entry) {
+		return false
+	}
+	for field, s := range q.Filters {
+		value := strings.ToLower(entry.Values[field])
+		if !strings.Contains(value, strings.ToLower(s)) {
+			return false
+		}
+	}
+	return true
+}
+
+// FormStore persists forms, their fields and entries, and the set of forms
+// each user owns. Redis is the default; see newStore for the others.
+type FormStore interface {
+	// Forms returns the IDs of all forms, including trashed ones.
+	Forms() ([]string, error)
+	Form(id string) (Form, error)
+	// SaveForm creates or updates form. Forms with Deleted set are kept in
+	// the trash until purged.
+	SaveForm(form Form) error
+	// TrashedForms returns the IDs of forms deleted at or before the given
+	// time.
+	TrashedForms(before int64) ([]string, error)
+	// PurgeForm permanently deletes the form and everything related to it.
+	PurgeForm(form Form) error
+
+	UserForms(uid string) ([]string, error)
+	AddUserForm(uid, id string) error
+	DeletedUserForms(uid string) ([]string, error)
+	DeleteUserForm(uid, id string) error
+	RestoreUserForm(uid, id string) error
+
+	// FormRole returns RoleOwner if uid owns the form, the role of email
+	// if the form is shared with it, or "" otherwise.
+	FormRole(id, uid, email string) (string, error)
+	Collaborators(id string) ([]Collaborator, error)
+	SetCollaborator(id string, collaborator Collaborator) error
+	RemoveCollaborator(id, email string) error
+	// SharedForms returns the IDs of forms shared with email.
+	SharedForms(email string) ([]string, error)
+
+	Fields(id string) ([]string, error)
+	// Schema returns the fields entries of the form must conform to, or
+	// nil if they can have any.
+	Schema(id string) ([]FieldSpec, error)
+	SaveSchema(id string, schema []FieldSpec) error
+
+	// AddEntry stores entry and registers its values' names as fields of
+	// the form. UpdateEntry replaces the values of an existing entry.
+	AddEntry(id string, entry Entry) error
+	UpdateEntry(id string, entry Entry) error
+	DeleteEntry(id, eid string) error
+	// Entry returns ErrNotFound if the form has no entry with the ID eid.
+	Entry(id, eid string) (Entry, error)
+	Entries(id string, q EntryQuery) ([]Entry, error)
+
+	// AddSpam keeps a filtered submission apart from the form's entries,
+	// without registering its fields. DeleteSpam returns the submission it
+	// deletes so it can be added as an entry instead, or ErrNotFound.
+	AddSpam(id string, entry Entry) error
+	DeleteSpam(id, eid string) (Entry, error)
+	Spam(id string, q EntryQuery) ([]Entry, error)
+}
+
+func newStore(backend string) (FormStore, error) {
+	switch backend {
+	case "redis":
+		return newRedisStore(rp), nil
+	case "bolt":
+		return newBoltStore(*boltPath)
+	case "postgres":
+		return newPostgresStore(*postgresURL)
+	case "memory":
+		return newMemoryStore(), nil
+	}
+	return nil, fmt.Errorf("unknown store %q", backend)
+}
+
+type collaboratorsByEmail []Collaborator
+
+func (c collaboratorsByEmail) Len() int           { return len(c) }
+func (c collaboratorsByEmail) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c collaboratorsByEmail) Less(i, j int) bool { return c[i].Email < c[j].Email }
+
+type entriesByNewest []Entry
+
+func (e entriesByNewest) Len() int      { return len(e) }
+func (e entriesByNewest) Swap(i, j int) { e[i], e[j] = e[j], e[i] }
+func (e entriesByNewest) Less(i, j int) bool {
+	if e[i].Submitted == e[j].Submitted {
+		return e[i].ID > e[j].ID
+	}
+	return e[i].Submitted > e[j].Submitted
+}
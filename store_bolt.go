@@ -0,0 +1,585 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// boltStore keeps everything in a single BoltDB file, mirroring the Redis
+// layout with nested buckets:
+//
+//	forms/<id>                       Form as JSON
+//	fields/<id>/<field>
+//	schemas/<id>                     []FieldSpec as JSON
+//	entries/<id>/<eid>               Entry as JSON
+//	index/<id>/<submitted><eid>      eid, ordered by submission time
+//	spam/<id>/<eid>                  Entry as JSON
+//	spamIndex/<id>/<submitted><eid>  eid, ordered by submission time
+//	users/<uid>/forms/<id>
+//	users/<uid>/deletedForms/<id>
+//	collaborators/<id>/<email>       role
+//	shared/<email>/<id>
+//	trash/<id>                       deletion time
+type boltStore struct {
+	db *bolt.DB
+}
+
+var (
+	formsBucket   = []byte("forms")
+	fieldsBucket  = []byte("fields")
+	schemasBucket = []byte("schemas")
+	entriesBucket = []byte("entries")
+	indexBucket   = []byte("index")
+	usersBucket   = []byte("users")
+	collabBucket  = []byte("collaborators")
+	sharedBucket  = []byte("shared")
+	trashBucket   = []byte("trash")
+	spamBucket    = []byte("spam")
+	spamIdxBucket = []byte("spamIndex")
+)
+
+func newBoltStore(path string) (*boltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{
+			formsBucket,
+			fieldsBucket,
+			schemasBucket,
+			entriesBucket,
+			indexBucket,
+			usersBucket,
+			collabBucket,
+			sharedBucket,
+			trashBucket,
+			spamBucket,
+			spamIdxBucket,
+		} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltStore{db: db}, nil
+}
+
+// bucket returns the bucket at path, or nil if any part of it is missing.
+func bucket(tx *bolt.Tx, path ...string) *bolt.Bucket {
+	b := tx.Bucket([]byte(path[0]))
+	for _, name := range path[1:] {
+		if b == nil {
+			return nil
+		}
+		b = b.Bucket([]byte(name))
+	}
+	return b
+}
+
+func createBucket(tx *bolt.Tx, path ...string) (*bolt.Bucket, error) {
+	b := tx.Bucket([]byte(path[0]))
+	for _, name := range path[1:] {
+		var err error
+		b, err = b.CreateBucketIfNotExists([]byte(name))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func bucketKeys(b *bolt.Bucket) []string {
+	var keys []string
+	if b == nil {
+		return keys
+	}
+	b.ForEach(func(k, v []byte) error {
+		keys = append(keys, string(k))
+		return nil
+	})
+	return keys
+}
+
+func indexKey(submitted int64, eid string) []byte {
+	return append(indexTime(submitted), eid...)
+}
+
+// indexTime encodes the time entries are indexed by. Keys sort unsigned, so
+// the sign bit is flipped to keep entries from before 1970, e.g. imported
+// ones, ahead of later ones.
+func indexTime(t int64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, uint64(t)^1<<63)
+	return k
+}
+
+func (s *boltStore) Forms() ([]string, error) {
+	var ids []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		ids = bucketKeys(tx.Bucket(formsBucket))
+		return nil
+	})
+	return ids, err
+}
+
+func (s *boltStore) Form(id string) (Form, error) {
+	var form Form
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(formsBucket).Get([]byte(id))
+		if v == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(v, &form)
+	})
+	return form, err
+}
+
+func timeKey(t int64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, uint64(t))
+	return k
+}
+
+func (s *boltStore) SaveForm(form Form) error {
+	v, err := json.Marshal(form)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(formsBucket).Put([]byte(form.ID), v); err != nil {
+			return err
+		}
+		if form.Deleted != 0 {
+			return tx.Bucket(trashBucket).Put([]byte(form.ID), timeKey(form.Deleted))
+		}
+		return tx.Bucket(trashBucket).Delete([]byte(form.ID))
+	})
+}
+
+func (s *boltStore) TrashedForms(before int64) ([]string, error) {
+	var ids []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(trashBucket).ForEach(func(k, v []byte) error {
+			if int64(binary.BigEndian.Uint64(v)) <= before {
+				ids = append(ids, string(k))
+			}
+			return nil
+		})
+	})
+	return ids, err
+}
+
+func (s *boltStore) PurgeForm(form Form) error {
+	id := []byte(form.ID)
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if b := bucket(tx, "collaborators", form.ID); b != nil {
+			for _, email := range bucketKeys(b) {
+				if shared := bucket(tx, "shared", email); shared != nil {
+					if err := shared.Delete(id); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		for _, name := range [][]byte{
+			fieldsBucket,
+			entriesBucket,
+			indexBucket,
+			collabBucket,
+			spamBucket,
+			spamIdxBucket,
+		} {
+			err := tx.Bucket(name).DeleteBucket(id)
+			if err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+		}
+		if form.Owner != "" {
+			for _, set := range []string{"forms", "deletedForms"} {
+				if b := bucket(tx, "users", form.Owner, set); b != nil {
+					if err := b.Delete(id); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		if err := tx.Bucket(schemasBucket).Delete(id); err != nil {
+			return err
+		}
+		if err := tx.Bucket(trashBucket).Delete(id); err != nil {
+			return err
+		}
+		return tx.Bucket(formsBucket).Delete(id)
+	})
+}
+
+func (s *boltStore) UserForms(uid string) ([]string, error) {
+	var ids []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		ids = bucketKeys(bucket(tx, "users", uid, "forms"))
+		return nil
+	})
+	return ids, err
+}
+
+func (s *boltStore) AddUserForm(uid, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := createBucket(tx, "users", uid, "forms")
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(id), []byte{})
+	})
+}
+
+func (s *boltStore) DeletedUserForms(uid string) ([]string, error) {
+	var ids []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		ids = bucketKeys(bucket(tx, "users", uid, "deletedForms"))
+		return nil
+	})
+	return ids, err
+}
+
+// moveUserForm moves id from one of uid's form sets to another.
+func (s *boltStore) moveUserForm(uid, id, from, to string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := createBucket(tx, "users", uid, to)
+		if err != nil {
+			return err
+		}
+		if err = b.Put([]byte(id), []byte{}); err != nil {
+			return err
+		}
+		if b = bucket(tx, "users", uid, from); b != nil {
+			return b.Delete([]byte(id))
+		}
+		return nil
+	})
+}
+
+func (s *boltStore) DeleteUserForm(uid, id string) error {
+	return s.moveUserForm(uid, id, "forms", "deletedForms")
+}
+
+func (s *boltStore) RestoreUserForm(uid, id string) error {
+	return s.moveUserForm(uid, id, "deletedForms", "forms")
+}
+
+func (s *boltStore) FormRole(id, uid, email string) (string, error) {
+	var role string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if b := bucket(tx, "users", uid, "forms"); b != nil && b.Get([]byte(id)) != nil {
+			role = RoleOwner
+			return nil
+		}
+		if b := bucket(tx, "collaborators", id); b != nil && email != "" {
+			role = string(b.Get([]byte(email)))
+		}
+		return nil
+	})
+	return role, err
+}
+
+func (s *boltStore) Collaborators(id string) ([]Collaborator, error) {
+	var collaborators []Collaborator
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := bucket(tx, "collaborators", id)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			collaborators = append(collaborators, Collaborator{string(k), string(v)})
+			return nil
+		})
+	})
+	return collaborators, err
+}
+
+func (s *boltStore) SetCollaborator(id string, collaborator Collaborator) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := createBucket(tx, "collaborators", id)
+		if err != nil {
+			return err
+		}
+		err = b.Put([]byte(collaborator.Email), []byte(collaborator.Role))
+		if err != nil {
+			return err
+		}
+		if b, err = createBucket(tx, "shared", collaborator.Email); err != nil {
+			return err
+		}
+		return b.Put([]byte(id), []byte{})
+	})
+}
+
+func (s *boltStore) RemoveCollaborator(id, email string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if b := bucket(tx, "collaborators", id); b != nil {
+			if err := b.Delete([]byte(email)); err != nil {
+				return err
+			}
+		}
+		if b := bucket(tx, "shared", email); b != nil {
+			return b.Delete([]byte(id))
+		}
+		return nil
+	})
+}
+
+func (s *boltStore) SharedForms(email string) ([]string, error) {
+	var ids []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		ids = bucketKeys(bucket(tx, "shared", email))
+		return nil
+	})
+	return ids, err
+}
+
+func (s *boltStore) Fields(id string) ([]string, error) {
+	var fields []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		fields = bucketKeys(bucket(tx, "fields", id))
+		return nil
+	})
+	return fields, err
+}
+
+func (s *boltStore) Schema(id string) ([]FieldSpec, error) {
+	var schema []FieldSpec
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(schemasBucket).Get([]byte(id))
+		if v == nil {
+			return nil
+		}
+		return json.Unmarshal(v, &schema)
+	})
+	return schema, err
+}
+
+func (s *boltStore) SaveSchema(id string, schema []FieldSpec) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if len(schema) == 0 {
+			return tx.Bucket(schemasBucket).Delete([]byte(id))
+		}
+		v, err := json.Marshal(schema)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(schemasBucket).Put([]byte(id), v)
+	})
+}
+
+func putEntry(tx *bolt.Tx, id string, entry Entry) error {
+	v, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	fields, err := createBucket(tx, "fields", id)
+	if err != nil {
+		return err
+	}
+	for field := range entry.Values {
+		if err := fields.Put([]byte(field), []byte{}); err != nil {
+			return err
+		}
+	}
+	entries, err := createBucket(tx, "entries", id)
+	if err != nil {
+		return err
+	}
+	return entries.Put([]byte(entry.ID), v)
+}
+
+func (s *boltStore) AddEntry(id string, entry Entry) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := putEntry(tx, id, entry); err != nil {
+			return err
+		}
+		index, err := createBucket(tx, "index", id)
+		if err != nil {
+			return err
+		}
+		return index.Put(indexKey(entry.Submitted, entry.ID), []byte(entry.ID))
+	})
+}
+
+func (s *boltStore) UpdateEntry(id string, entry Entry) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putEntry(tx, id, entry)
+	})
+}
+
+func (s *boltStore) DeleteEntry(id, eid string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		entries := bucket(tx, "entries", id)
+		if entries == nil {
+			return nil
+		}
+		v := entries.Get([]byte(eid))
+		if v == nil {
+			return nil
+		}
+		var entry Entry
+		if err := json.Unmarshal(v, &entry); err != nil {
+			return err
+		}
+		if index := bucket(tx, "index", id); index != nil {
+			if err := index.Delete(indexKey(entry.Submitted, eid)); err != nil {
+				return err
+			}
+		}
+		return entries.Delete([]byte(eid))
+	})
+}
+
+func (s *boltStore) Entry(id, eid string) (Entry, error) {
+	var entry Entry
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := bucket(tx, "entries", id)
+		if b == nil {
+			return ErrNotFound
+		}
+		v := b.Get([]byte(eid))
+		if v == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(v, &entry)
+	})
+	return entry, err
+}
+
+func (s *boltStore) Entries(id string, q EntryQuery) ([]Entry, error) {
+	return s.indexedEntries("entries", "index", id, q)
+}
+
+// indexedEntries reads the entries matching q from the entries and index
+// buckets named, which differ for entries and spam.
+func (s *boltStore) indexedEntries(entriesName, indexName, id string, q EntryQuery) ([]Entry, error) {
+	var entries []Entry
+	err := s.db.View(func(tx *bolt.Tx) error {
+		index := bucket(tx, indexName, id)
+		if index == nil {
+			return nil
+		}
+		b := bucket(tx, entriesName, id)
+		c := index.Cursor()
+
+		var k, eid []byte
+		next := c.Prev
+		if q.Oldest {
+			next = c.Next
+			var start []byte
+			if q.Since != 0 {
+				start = indexTime(q.Since)
+			}
+			if q.After.ID != "" {
+				if after := indexKey(q.After.Submitted, q.After.ID); bytes.Compare(after, start) > 0 {
+					start = after
+				}
+			}
+			if start == nil {
+				k, eid = c.First()
+			} else {
+				k, eid = c.Seek(start)
+			}
+		} else {
+			var end []byte
+			if q.Until != 0 {
+				end = indexTime(q.Until + 1)
+			}
+			if q.After.ID != "" {
+				if after := indexKey(q.After.Submitted, q.After.ID); end == nil || bytes.Compare(after, end) < 0 {
+					end = after
+				}
+			}
+			if end == nil {
+				k, eid = c.Last()
+			} else if k, _ = c.Seek(end); k == nil {
+				k, eid = c.Last()
+			} else {
+				k, eid = c.Prev()
+			}
+		}
+
+		for ; k != nil; k, eid = next() {
+			var entry Entry
+			if err := json.Unmarshal(b.Get(eid), &entry); err != nil {
+				return err
+			}
+			// The cursor starts within range, so the first entry out of
+			// it is past the end.
+			if !q.inRange(entry) {
+				break
+			}
+			if !q.matches(entry) {
+				continue
+			}
+			entries = append(entries, entry)
+			if q.Limit > 0 && len(entries) == q.Limit {
+				break
+			}
+		}
+		return nil
+	})
+	return entries, err
+}
+
+func (s *boltStore) AddSpam(id string, entry Entry) error {
+	v, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		spam, err := createBucket(tx, "spam", id)
+		if err != nil {
+			return err
+		}
+		if err = spam.Put([]byte(entry.ID), v); err != nil {
+			return err
+		}
+		index, err := createBucket(tx, "spamIndex", id)
+		if err != nil {
+			return err
+		}
+		return index.Put(indexKey(entry.Submitted, entry.ID), []byte(entry.ID))
+	})
+}
+
+func (s *boltStore) DeleteSpam(id, eid string) (Entry, error) {
+	var entry Entry
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		spam := bucket(tx, "spam", id)
+		if spam == nil {
+			return ErrNotFound
+		}
+		v := spam.Get([]byte(eid))
+		if v == nil {
+			return ErrNotFound
+		}
+		if err := json.Unmarshal(v, &entry); err != nil {
+			return err
+		}
+		if index := bucket(tx, "spamIndex", id); index != nil {
+			if err := index.Delete(indexKey(entry.Submitted, eid)); err != nil {
+				return err
+			}
+		}
+		return spam.Delete([]byte(eid))
+	})
+	return entry, err
+}
+
+func (s *boltStore) Spam(id string, q EntryQuery) ([]Entry, error) {
+	return s.indexedEntries("spam", "spamIndex", id, q)
+}
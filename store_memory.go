@@ -0,0 +1,330 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+// memoryStore keeps everything in process memory. It's meant for tests and
+// trying Formic out; nothing survives a restart.
+type memoryStore struct {
+	mu           sync.RWMutex
+	forms        map[string]Form
+	fields       map[string]map[string]bool
+	schemas      map[string][]FieldSpec
+	entries      map[string]map[string]Entry
+	spam         map[string]map[string]Entry
+	userForms    map[string]map[string]bool
+	deletedForms map[string]map[string]bool
+	roles        map[string]map[string]string
+	shared       map[string]map[string]bool
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		forms:        make(map[string]Form),
+		fields:       make(map[string]map[string]bool),
+		schemas:      make(map[string][]FieldSpec),
+		entries:      make(map[string]map[string]Entry),
+		spam:         make(map[string]map[string]Entry),
+		userForms:    make(map[string]map[string]bool),
+		deletedForms: make(map[string]map[string]bool),
+		roles:        make(map[string]map[string]string),
+		shared:       make(map[string]map[string]bool),
+	}
+}
+
+func addToSet(sets map[string]map[string]bool, k, v string) {
+	if sets[k] == nil {
+		sets[k] = make(map[string]bool)
+	}
+	sets[k][v] = true
+}
+
+func setMembers(set map[string]bool) []string {
+	members := make([]string, 0, len(set))
+	for m := range set {
+		members = append(members, m)
+	}
+	return members
+}
+
+// copyEntry copies the entry's values, so entries handed out or stored
+// can't be changed without the lock.
+func copyEntry(entry Entry) Entry {
+	values := make(map[string]string, len(entry.Values))
+	for field, value := range entry.Values {
+		values[field] = value
+	}
+	entry.Values = values
+	return entry
+}
+
+func (s *memoryStore) Forms() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := make([]string, 0, len(s.forms))
+	for id := range s.forms {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (s *memoryStore) Form(id string) (Form, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	form, ok := s.forms[id]
+	if !ok {
+		return form, ErrNotFound
+	}
+	return form, nil
+}
+
+func (s *memoryStore) SaveForm(form Form) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.forms[form.ID] = form
+	return nil
+}
+
+func (s *memoryStore) TrashedForms(before int64) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var ids []string
+	for id, form := range s.forms {
+		if form.Deleted != 0 && form.Deleted <= before {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (s *memoryStore) PurgeForm(form Form) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for email := range s.roles[form.ID] {
+		delete(s.shared[email], form.ID)
+	}
+	delete(s.roles, form.ID)
+	delete(s.fields, form.ID)
+	delete(s.schemas, form.ID)
+	delete(s.entries, form.ID)
+	delete(s.spam, form.ID)
+	delete(s.userForms[form.Owner], form.ID)
+	delete(s.deletedForms[form.Owner], form.ID)
+	delete(s.forms, form.ID)
+	return nil
+}
+
+func (s *memoryStore) UserForms(uid string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return setMembers(s.userForms[uid]), nil
+}
+
+func (s *memoryStore) AddUserForm(uid, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	addToSet(s.userForms, uid, id)
+	return nil
+}
+
+func (s *memoryStore) DeletedUserForms(uid string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return setMembers(s.deletedForms[uid]), nil
+}
+
+func (s *memoryStore) DeleteUserForm(uid, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	addToSet(s.deletedForms, uid, id)
+	delete(s.userForms[uid], id)
+	return nil
+}
+
+func (s *memoryStore) RestoreUserForm(uid, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	addToSet(s.userForms, uid, id)
+	delete(s.deletedForms[uid], id)
+	return nil
+}
+
+func (s *memoryStore) FormRole(id, uid, email string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.userForms[uid][id] {
+		return RoleOwner, nil
+	}
+	return s.roles[id][email], nil
+}
+
+func (s *memoryStore) Collaborators(id string) ([]Collaborator, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	collaborators := make([]Collaborator, 0, len(s.roles[id]))
+	for email, role := range s.roles[id] {
+		collaborators = append(collaborators, Collaborator{email, role})
+	}
+	sort.Sort(collaboratorsByEmail(collaborators))
+	return collaborators, nil
+}
+
+func (s *memoryStore) SetCollaborator(id string, collaborator Collaborator) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.roles[id] == nil {
+		s.roles[id] = make(map[string]string)
+	}
+	s.roles[id][collaborator.Email] = collaborator.Role
+	addToSet(s.shared, collaborator.Email, id)
+	return nil
+}
+
+func (s *memoryStore) RemoveCollaborator(id, email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.roles[id], email)
+	delete(s.shared[email], id)
+	return nil
+}
+
+func (s *memoryStore) SharedForms(email string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return setMembers(s.shared[email]), nil
+}
+
+func (s *memoryStore) Fields(id string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return setMembers(s.fields[id]), nil
+}
+
+func (s *memoryStore) Schema(id string) ([]FieldSpec, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.schemas[id], nil
+}
+
+func (s *memoryStore) SaveSchema(id string, schema []FieldSpec) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(schema) == 0 {
+		delete(s.schemas, id)
+		return nil
+	}
+	s.schemas[id] = schema
+	return nil
+}
+
+func (s *memoryStore) AddEntry(id string, entry Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for field := range entry.Values {
+		addToSet(s.fields, id, field)
+	}
+	if s.entries[id] == nil {
+		s.entries[id] = make(map[string]Entry)
+	}
+	s.entries[id][entry.ID] = copyEntry(entry)
+	return nil
+}
+
+func (s *memoryStore) UpdateEntry(id string, entry Entry) error {
+	return s.AddEntry(id, entry)
+}
+
+func (s *memoryStore) DeleteEntry(id, eid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries[id], eid)
+	return nil
+}
+
+func (s *memoryStore) Entry(id, eid string) (Entry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry, ok := s.entries[id][eid]
+	if !ok {
+		return entry, ErrNotFound
+	}
+	return copyEntry(entry), nil
+}
+
+func (s *memoryStore) Entries(id string, q EntryQuery) ([]Entry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return filterEntries(s.entries[id], q), nil
+}
+
+func filterEntries(all map[string]Entry, q EntryQuery) []Entry {
+	entries := make([]Entry, 0, len(all))
+	for _, entry := range all {
+		if q.matches(entry) {
+			entries = append(entries, copyEntry(entry))
+		}
+	}
+	if q.Oldest {
+		sort.Sort(sort.Reverse(entriesByNewest(entries)))
+	} else {
+		sort.Sort(entriesByNewest(entries))
+	}
+	if q.Limit > 0 && len(entries) > q.Limit {
+		entries = entries[:q.Limit]
+	}
+	return entries
+}
+
+func (s *memoryStore) AddSpam(id string, entry Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.spam[id] == nil {
+		s.spam[id] = make(map[string]Entry)
+	}
+	s.spam[id][entry.ID] = copyEntry(entry)
+	return nil
+}
+
+func (s *memoryStore) DeleteSpam(id, eid string) (Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.spam[id][eid]
+	if !ok {
+		return entry, ErrNotFound
+	}
+	delete(s.spam[id], eid)
+	return entry, nil
+}
+
+func (s *memoryStore) Spam(id string, q EntryQuery) ([]Entry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return filterEntries(s.spam[id], q), nil
+}
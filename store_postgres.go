@@ -0,0 +1,525 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	_ "github.com/lib/pq"
+)
+
+type postgresStore struct {
+	db *sql.DB
+}
+
+// migrations are applied in order, each in its own transaction, and
+// recorded in schema_migrations. Only ever append to this list.
+var migrations = []string{
+	`CREATE TABLE forms (
+		id text PRIMARY KEY,
+		data jsonb NOT NULL
+	);
+	CREATE TABLE form_owners (
+		uid text NOT NULL,
+		form_id text NOT NULL,
+		deleted boolean NOT NULL DEFAULT false,
+		PRIMARY KEY (uid, form_id)
+	);
+	CREATE TABLE fields (
+		form_id text NOT NULL REFERENCES forms (id) ON DELETE CASCADE,
+		name text NOT NULL,
+		PRIMARY KEY (form_id, name)
+	);
+	CREATE TABLE entries (
+		form_id text NOT NULL REFERENCES forms (id) ON DELETE CASCADE,
+		id text NOT NULL,
+		submitted timestamptz NOT NULL,
+		data jsonb NOT NULL DEFAULT '{}',
+		PRIMARY KEY (form_id, id)
+	);
+	CREATE INDEX entries_submitted_idx ON entries (form_id, submitted DESC, id DESC);
+	CREATE INDEX entries_data_idx ON entries USING gin (data);`,
+
+	`CREATE TABLE collaborators (
+		form_id text NOT NULL REFERENCES forms (id) ON DELETE CASCADE,
+		email text NOT NULL,
+		role text NOT NULL,
+		PRIMARY KEY (form_id, email)
+	);
+	CREATE INDEX collaborators_email_idx ON collaborators (email);`,
+
+	`CREATE TABLE schemas (
+		form_id text PRIMARY KEY REFERENCES forms (id) ON DELETE CASCADE,
+		data jsonb NOT NULL
+	);`,
+
+	`CREATE TABLE spam (
+		form_id text NOT NULL REFERENCES forms (id) ON DELETE CASCADE,
+		id text NOT NULL,
+		submitted timestamptz NOT NULL,
+		data jsonb NOT NULL DEFAULT '{}',
+		PRIMARY KEY (form_id, id)
+	);
+	CREATE INDEX spam_submitted_idx ON spam (form_id, submitted DESC, id DESC);`,
+}
+
+func newPostgresStore(url string) (*postgresStore, error) {
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		return nil, err
+	}
+	if err = migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &postgresStore{db: db}, nil
+}
+
+func migrate(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version integer PRIMARY KEY
+	)`)
+	if err != nil {
+		return err
+	}
+
+	var version int
+	err = db.QueryRow(
+		`SELECT coalesce(max(version), 0) FROM schema_migrations`,
+	).Scan(&version)
+	if err != nil {
+		return err
+	}
+
+	for ; version < len(migrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err = tx.Exec(migrations[version]); err == nil {
+			_, err = tx.Exec(
+				`INSERT INTO schema_migrations (version) VALUES ($1)`,
+				version+1,
+			)
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err = tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func queryStrings(db *sql.DB, query string, args ...interface{}) ([]string, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
+
+func (s *postgresStore) Forms() ([]string, error) {
+	return queryStrings(s.db, `SELECT id FROM forms`)
+}
+
+func (s *postgresStore) Form(id string) (Form, error) {
+	var (
+		form Form
+		data []byte
+	)
+	err := s.db.QueryRow(`SELECT data FROM forms WHERE id = $1`, id).Scan(&data)
+	if err == sql.ErrNoRows {
+		return form, ErrNotFound
+	}
+	if err != nil {
+		return form, err
+	}
+	err = json.Unmarshal(data, &form)
+	return form, err
+}
+
+func (s *postgresStore) SaveForm(form Form) error {
+	data, err := json.Marshal(form)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`
+		INSERT INTO forms (id, data) VALUES ($1, $2)
+		ON CONFLICT (id) DO UPDATE SET data = excluded.data`,
+		form.ID, string(data),
+	)
+	return err
+}
+
+func (s *postgresStore) TrashedForms(before int64) ([]string, error) {
+	return queryStrings(s.db, `
+		SELECT id FROM forms
+		WHERE (data->>'Deleted')::bigint BETWEEN 1 AND $1`,
+		before,
+	)
+}
+
+func (s *postgresStore) PurgeForm(form Form) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM form_owners WHERE form_id = $1`, form.ID)
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM forms WHERE id = $1`, form.ID)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *postgresStore) UserForms(uid string) ([]string, error) {
+	return queryStrings(s.db, `
+		SELECT form_id FROM form_owners
+		WHERE uid = $1 AND NOT deleted`,
+		uid,
+	)
+}
+
+func (s *postgresStore) AddUserForm(uid, id string) error {
+	_, err := s.db.Exec(`
+		INSERT INTO form_owners (uid, form_id) VALUES ($1, $2)
+		ON CONFLICT (uid, form_id) DO UPDATE SET deleted = false`,
+		uid, id,
+	)
+	return err
+}
+
+func (s *postgresStore) DeletedUserForms(uid string) ([]string, error) {
+	return queryStrings(s.db, `
+		SELECT form_id FROM form_owners
+		WHERE uid = $1 AND deleted`,
+		uid,
+	)
+}
+
+func (s *postgresStore) RestoreUserForm(uid, id string) error {
+	_, err := s.db.Exec(`
+		UPDATE form_owners SET deleted = false
+		WHERE uid = $1 AND form_id = $2`,
+		uid, id,
+	)
+	return err
+}
+
+func (s *postgresStore) DeleteUserForm(uid, id string) error {
+	_, err := s.db.Exec(`
+		INSERT INTO form_owners (uid, form_id, deleted) VALUES ($1, $2, true)
+		ON CONFLICT (uid, form_id) DO UPDATE SET deleted = true`,
+		uid, id,
+	)
+	return err
+}
+
+func (s *postgresStore) FormRole(id, uid, email string) (string, error) {
+	var role string
+	// Owners who are also collaborators stay owners.
+	err := s.db.QueryRow(`
+		SELECT role FROM (
+			SELECT 'owner' AS role, 0 AS precedence FROM form_owners
+			WHERE form_id = $1 AND uid = $2 AND NOT deleted
+			UNION ALL
+			SELECT role, 1 FROM collaborators
+			WHERE form_id = $1 AND email = $3
+		) roles
+		ORDER BY precedence
+		LIMIT 1`,
+		id, uid, email,
+	).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return role, err
+}
+
+func (s *postgresStore) Collaborators(id string) ([]Collaborator, error) {
+	rows, err := s.db.Query(`
+		SELECT email, role FROM collaborators
+		WHERE form_id = $1
+		ORDER BY email`,
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var collaborators []Collaborator
+	for rows.Next() {
+		var c Collaborator
+		if err := rows.Scan(&c.Email, &c.Role); err != nil {
+			return nil, err
+		}
+		collaborators = append(collaborators, c)
+	}
+	return collaborators, rows.Err()
+}
+
+func (s *postgresStore) SetCollaborator(id string, collaborator Collaborator) error {
+	_, err := s.db.Exec(`
+		INSERT INTO collaborators (form_id, email, role) VALUES ($1, $2, $3)
+		ON CONFLICT (form_id, email) DO UPDATE SET role = excluded.role`,
+		id, collaborator.Email, collaborator.Role,
+	)
+	return err
+}
+
+func (s *postgresStore) RemoveCollaborator(id, email string) error {
+	_, err := s.db.Exec(
+		`DELETE FROM collaborators WHERE form_id = $1 AND email = $2`,
+		id, email,
+	)
+	return err
+}
+
+func (s *postgresStore) SharedForms(email string) ([]string, error) {
+	return queryStrings(s.db,
+		`SELECT form_id FROM collaborators WHERE email = $1`,
+		email,
+	)
+}
+
+func (s *postgresStore) Fields(id string) ([]string, error) {
+	return queryStrings(s.db, `
+		SELECT name FROM fields WHERE form_id = $1 ORDER BY name`,
+		id,
+	)
+}
+
+func (s *postgresStore) Schema(id string) ([]FieldSpec, error) {
+	var data []byte
+	err := s.db.QueryRow(
+		`SELECT data FROM schemas WHERE form_id = $1`, id,
+	).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var schema []FieldSpec
+	err = json.Unmarshal(data, &schema)
+	return schema, err
+}
+
+func (s *postgresStore) SaveSchema(id string, schema []FieldSpec) error {
+	if len(schema) == 0 {
+		_, err := s.db.Exec(`DELETE FROM schemas WHERE form_id = $1`, id)
+		return err
+	}
+	data, err := json.Marshal(schema)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`
+		INSERT INTO schemas (form_id, data) VALUES ($1, $2)
+		ON CONFLICT (form_id) DO UPDATE SET data = excluded.data`,
+		id, string(data),
+	)
+	return err
+}
+
+// writeEntry registers the entry's fields and calls write with its values
+// as JSON in the same transaction.
+func (s *postgresStore) writeEntry(id string, entry Entry, write func(tx *sql.Tx, data string) error) error {
+	data, err := json.Marshal(entry.Values)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	for field := range entry.Values {
+		_, err = tx.Exec(`
+			INSERT INTO fields (form_id, name) VALUES ($1, $2)
+			ON CONFLICT DO NOTHING`,
+			id, field,
+		)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err = write(tx, string(data)); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *postgresStore) AddEntry(id string, entry Entry) error {
+	return s.writeEntry(id, entry, func(tx *sql.Tx, data string) error {
+		_, err := tx.Exec(`
+			INSERT INTO entries (form_id, id, submitted, data)
+			VALUES ($1, $2, to_timestamp($3), $4)`,
+			id, entry.ID, entry.Submitted, data,
+		)
+		return err
+	})
+}
+
+func (s *postgresStore) UpdateEntry(id string, entry Entry) error {
+	return s.writeEntry(id, entry, func(tx *sql.Tx, data string) error {
+		_, err := tx.Exec(`
+			UPDATE entries SET data = $3
+			WHERE form_id = $1 AND id = $2`,
+			id, entry.ID, data,
+		)
+		return err
+	})
+}
+
+func (s *postgresStore) DeleteEntry(id, eid string) error {
+	_, err := s.db.Exec(
+		`DELETE FROM entries WHERE form_id = $1 AND id = $2`,
+		id, eid,
+	)
+	return err
+}
+
+func (s *postgresStore) Entry(id, eid string) (Entry, error) {
+	var (
+		entry = Entry{ID: eid}
+		data  []byte
+	)
+	err := s.db.QueryRow(`
+		SELECT extract(epoch FROM submitted)::bigint, data
+		FROM entries
+		WHERE form_id = $1 AND id = $2`,
+		id, eid,
+	).Scan(&entry.Submitted, &data)
+	if err == sql.ErrNoRows {
+		return entry, ErrNotFound
+	}
+	if err != nil {
+		return entry, err
+	}
+	err = json.Unmarshal(data, &entry.Values)
+	return entry, err
+}
+
+func (s *postgresStore) Entries(id string, q EntryQuery) ([]Entry, error) {
+	return s.selectEntries("entries", id, q)
+}
+
+// selectEntries reads the entries matching q from table, which is either
+// entries or spam.
+func (s *postgresStore) selectEntries(table, id string, q EntryQuery) ([]Entry, error) {
+	query := `
+		SELECT id, extract(epoch FROM submitted)::bigint, data
+		FROM ` + table + `
+		WHERE form_id = $1`
+	args := []interface{}{id}
+	if q.Since != 0 {
+		args = append(args, q.Since)
+		query += fmt.Sprintf(" AND submitted >= to_timestamp($%d)", len(args))
+	}
+	if q.Until != 0 {
+		args = append(args, q.Until)
+		query += fmt.Sprintf(" AND submitted <= to_timestamp($%d)", len(args))
+	}
+	if q.After.ID != "" {
+		op := "<"
+		if q.Oldest {
+			op = ">"
+		}
+		args = append(args, q.After.Submitted, q.After.ID)
+		query += fmt.Sprintf(" AND (submitted, id) %s (to_timestamp($%d), $%d)",
+			op, len(args)-1, len(args))
+	}
+	for field, value := range q.Filters {
+		args = append(args, field, value)
+		query += fmt.Sprintf(" AND strpos(lower(data->>$%d), lower($%d)) > 0",
+			len(args)-1, len(args))
+	}
+	if q.Oldest {
+		query += " ORDER BY submitted, id"
+	} else {
+		query += " ORDER BY submitted DESC, id DESC"
+	}
+	if q.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", q.Limit)
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []Entry
+	for rows.Next() {
+		var (
+			entry Entry
+			data  []byte
+		)
+		if err := rows.Scan(&entry.ID, &entry.Submitted, &data); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &entry.Values); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (s *postgresStore) AddSpam(id string, entry Entry) error {
+	data, err := json.Marshal(entry.Values)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`
+		INSERT INTO spam (form_id, id, submitted, data)
+		VALUES ($1, $2, to_timestamp($3), $4)`,
+		id, entry.ID, entry.Submitted, string(data),
+	)
+	return err
+}
+
+func (s *postgresStore) DeleteSpam(id, eid string) (Entry, error) {
+	var (
+		entry = Entry{ID: eid}
+		data  []byte
+	)
+	err := s.db.QueryRow(`
+		DELETE FROM spam
+		WHERE form_id = $1 AND id = $2
+		RETURNING extract(epoch FROM submitted)::bigint, data`,
+		id, eid,
+	).Scan(&entry.Submitted, &data)
+	if err == sql.ErrNoRows {
+		return entry, ErrNotFound
+	}
+	if err != nil {
+		return entry, err
+	}
+	err = json.Unmarshal(data, &entry.Values)
+	return entry, err
+}
+
+func (s *postgresStore) Spam(id string, q EntryQuery) ([]Entry, error) {
+	return s.selectEntries("spam", id, q)
+}
@@ -0,0 +1,740 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+type redisStore struct {
+	pool *redis.Pool
+}
+
+func newRedisStore(pool *redis.Pool) *redisStore {
+	return &redisStore{pool: pool}
+}
+
+func key(args ...string) string {
+	args = append([]string{"formic"}, args...)
+	return strings.Join(args, ":")
+}
+
+func stringMap(reply interface{}, err error) (map[string]string, error) {
+	v, err := redis.Strings(reply, err)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]string, len(v)/2)
+	for i := 0; i < len(v); i += 2 {
+		m[v[i]] = v[i+1]
+	}
+	return m, nil
+}
+
+func (s *redisStore) Forms() ([]string, error) {
+	rc := s.pool.Get()
+	defer rc.Close()
+
+	return redis.Strings(rc.Do("SMEMBERS", key("forms")))
+}
+
+func (s *redisStore) Form(id string) (Form, error) {
+	var form Form
+
+	rc := s.pool.Get()
+	defer rc.Close()
+
+	v, err := redis.Values(rc.Do("HGETALL", key("form", id)))
+	if err != nil {
+		return form, err
+	}
+	if len(v) == 0 {
+		return form, ErrNotFound
+	}
+	err = redis.ScanStruct(v, &form)
+	return form, err
+}
+
+func (s *redisStore) SaveForm(form Form) error {
+	rc := s.pool.Get()
+	defer rc.Close()
+
+	return exec(rc, func() error {
+		err := rc.Send("HMSET", redis.Args{key("form", form.ID)}.AddFlat(form)...)
+		if err != nil {
+			return err
+		}
+		if err = rc.Send("SADD", key("forms"), form.ID); err != nil {
+			return err
+		}
+		if form.Deleted != 0 {
+			return rc.Send("ZADD", key("trash"), form.Deleted, form.ID)
+		}
+		return rc.Send("ZREM", key("trash"), form.ID)
+	})
+}
+
+func (s *redisStore) TrashedForms(before int64) ([]string, error) {
+	rc := s.pool.Get()
+	defer rc.Close()
+
+	return redis.Strings(rc.Do("ZRANGEBYSCORE", key("trash"), "-inf", before))
+}
+
+// PurgeForm deletes every key under formic:form:<id>: along with the
+// references to the form from other keys.
+func (s *redisStore) PurgeForm(form Form) error {
+	rc := s.pool.Get()
+	defer rc.Close()
+
+	collaborators, err := s.Collaborators(form.ID)
+	if err != nil {
+		return err
+	}
+
+	cursor := "0"
+	for {
+		v, err := redis.Values(rc.Do(
+			"SCAN", cursor,
+			"MATCH", key("form", form.ID, "*"),
+			"COUNT", 1000,
+		))
+		if err != nil {
+			return err
+		}
+		var keys []string
+		if _, err = redis.Scan(v, &cursor, &keys); err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			if _, err = rc.Do("DEL", redis.Args{}.AddFlat(keys)...); err != nil {
+				return err
+			}
+		}
+		if cursor == "0" {
+			break
+		}
+	}
+
+	return exec(rc, func() error {
+		for _, collaborator := range collaborators {
+			if err := rc.Send("SREM", key("shared", collaborator.Email), form.ID); err != nil {
+				return err
+			}
+		}
+		if form.Owner != "" {
+			if err := rc.Send("SREM", key(form.Owner, "forms"), form.ID); err != nil {
+				return err
+			}
+			if err := rc.Send("SREM", key(form.Owner, "deletedForms"), form.ID); err != nil {
+				return err
+			}
+		}
+		if err := rc.Send("ZREM", key("trash"), form.ID); err != nil {
+			return err
+		}
+		if err := rc.Send("SREM", key("forms"), form.ID); err != nil {
+			return err
+		}
+		return rc.Send("DEL", key("form", form.ID))
+	})
+}
+
+func (s *redisStore) UserForms(uid string) ([]string, error) {
+	rc := s.pool.Get()
+	defer rc.Close()
+
+	return redis.Strings(rc.Do("SMEMBERS", key(uid, "forms")))
+}
+
+func (s *redisStore) AddUserForm(uid, id string) error {
+	rc := s.pool.Get()
+	defer rc.Close()
+
+	_, err := rc.Do("SADD", key(uid, "forms"), id)
+	return err
+}
+
+func (s *redisStore) DeletedUserForms(uid string) ([]string, error) {
+	rc := s.pool.Get()
+	defer rc.Close()
+
+	return redis.Strings(rc.Do("SMEMBERS", key(uid, "deletedForms")))
+}
+
+func (s *redisStore) DeleteUserForm(uid, id string) error {
+	rc := s.pool.Get()
+	defer rc.Close()
+
+	_, err := rc.Do("SMOVE", key(uid, "forms"), key(uid, "deletedForms"), id)
+	return err
+}
+
+func (s *redisStore) RestoreUserForm(uid, id string) error {
+	rc := s.pool.Get()
+	defer rc.Close()
+
+	_, err := rc.Do("SMOVE", key(uid, "deletedForms"), key(uid, "forms"), id)
+	return err
+}
+
+func (s *redisStore) FormRole(id, uid, email string) (string, error) {
+	rc := s.pool.Get()
+	defer rc.Close()
+
+	owns, err := redis.Bool(rc.Do("SISMEMBER", key(uid, "forms"), id))
+	if err != nil {
+		return "", err
+	}
+	if owns {
+		return RoleOwner, nil
+	}
+	if email == "" {
+		return "", nil
+	}
+	role, err := redis.String(rc.Do("HGET", key("form", id, "collaborators"), email))
+	if err == redis.ErrNil {
+		return "", nil
+	}
+	return role, err
+}
+
+func (s *redisStore) Collaborators(id string) ([]Collaborator, error) {
+	rc := s.pool.Get()
+	defer rc.Close()
+
+	roles, err := stringMap(rc.Do("HGETALL", key("form", id, "collaborators")))
+	if err != nil {
+		return nil, err
+	}
+	collaborators := make([]Collaborator, 0, len(roles))
+	for email, role := range roles {
+		collaborators = append(collaborators, Collaborator{email, role})
+	}
+	sort.Sort(collaboratorsByEmail(collaborators))
+	return collaborators, nil
+}
+
+func (s *redisStore) SetCollaborator(id string, collaborator Collaborator) error {
+	rc := s.pool.Get()
+	defer rc.Close()
+
+	return exec(rc, func() error {
+		err := rc.Send(
+			"HSET", key("form", id, "collaborators"),
+			collaborator.Email, collaborator.Role,
+		)
+		if err != nil {
+			return err
+		}
+		return rc.Send("SADD", key("shared", collaborator.Email), id)
+	})
+}
+
+func (s *redisStore) RemoveCollaborator(id, email string) error {
+	rc := s.pool.Get()
+	defer rc.Close()
+
+	return exec(rc, func() error {
+		if err := rc.Send("HDEL", key("form", id, "collaborators"), email); err != nil {
+			return err
+		}
+		return rc.Send("SREM", key("shared", email), id)
+	})
+}
+
+func (s *redisStore) SharedForms(email string) ([]string, error) {
+	rc := s.pool.Get()
+	defer rc.Close()
+
+	return redis.Strings(rc.Do("SMEMBERS", key("shared", email)))
+}
+
+func (s *redisStore) Fields(id string) ([]string, error) {
+	rc := s.pool.Get()
+	defer rc.Close()
+
+	return redis.Strings(rc.Do("SMEMBERS", key("form", id, "fields")))
+}
+
+// exec runs the commands queued by send in a MULTI/EXEC transaction and
+// returns the first error, either from queueing or from any of the
+// commands.
+func exec(rc redis.Conn, send func() error) error {
+	if err := rc.Send("MULTI"); err != nil {
+		return err
+	}
+	if err := send(); err != nil {
+		rc.Do("DISCARD")
+		return err
+	}
+	replies, err := redis.Values(rc.Do("EXEC"))
+	if err != nil {
+		return err
+	}
+	for _, reply := range replies {
+		if err, ok := reply.(redis.Error); ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendEntryValues queues the commands replacing the values of an entry.
+func sendEntryValues(rc redis.Conn, id string, entry Entry) error {
+	k := key("form", id, "entry", entry.ID)
+	if err := rc.Send("DEL", k); err != nil {
+		return err
+	}
+	if len(entry.Values) == 0 {
+		return nil
+	}
+	fields := redis.Args{key("form", id, "fields")}
+	for field := range entry.Values {
+		fields = fields.Add(field)
+	}
+	if err := rc.Send("SADD", fields...); err != nil {
+		return err
+	}
+	return rc.Send("HMSET", redis.Args{k}.AddFlat(entry.Values)...)
+}
+
+func (s *redisStore) Schema(id string) ([]FieldSpec, error) {
+	rc := s.pool.Get()
+	defer rc.Close()
+
+	data, err := redis.Bytes(rc.Do("GET", key("form", id, "schema")))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var schema []FieldSpec
+	err = json.Unmarshal(data, &schema)
+	return schema, err
+}
+
+func (s *redisStore) SaveSchema(id string, schema []FieldSpec) error {
+	rc := s.pool.Get()
+	defer rc.Close()
+
+	if len(schema) == 0 {
+		_, err := rc.Do("DEL", key("form", id, "schema"))
+		return err
+	}
+	data, err := json.Marshal(schema)
+	if err != nil {
+		return err
+	}
+	_, err = rc.Do("SET", key("form", id, "schema"), data)
+	return err
+}
+
+func (s *redisStore) AddEntry(id string, entry Entry) error {
+	rc := s.pool.Get()
+	defer rc.Close()
+
+	return exec(rc, func() error {
+		if err := sendEntryValues(rc, id, entry); err != nil {
+			return err
+		}
+		return rc.Send("ZADD", key("form", id, "entries"), entry.Submitted, entry.ID)
+	})
+}
+
+func (s *redisStore) UpdateEntry(id string, entry Entry) error {
+	rc := s.pool.Get()
+	defer rc.Close()
+
+	return exec(rc, func() error {
+		return sendEntryValues(rc, id, entry)
+	})
+}
+
+func (s *redisStore) DeleteEntry(id, eid string) error {
+	rc := s.pool.Get()
+	defer rc.Close()
+
+	return exec(rc, func() error {
+		if err := rc.Send("ZREM", key("form", id, "entries"), eid); err != nil {
+			return err
+		}
+		return rc.Send("DEL", key("form", id, "entry", eid))
+	})
+}
+
+func (s *redisStore) Entry(id, eid string) (Entry, error) {
+	rc := s.pool.Get()
+	defer rc.Close()
+
+	return readEntry(rc, key("form", id, "entries"), key("form", id, "entry", eid), eid)
+}
+
+// readEntry reads the entry with the ID eid from the index and hash keys it
+// is stored under, which differ for entries and spam.
+func readEntry(rc redis.Conn, index, k, eid string) (Entry, error) {
+	entry := Entry{ID: eid}
+
+	rc.Send("ZSCORE", index, eid)
+	rc.Send("HGETALL", k)
+	rc.Flush()
+
+	submitted, err := redis.Int64(rc.Receive())
+	if err == redis.ErrNil {
+		rc.Receive()
+		return entry, ErrNotFound
+	}
+	if err != nil {
+		rc.Receive()
+		return entry, err
+	}
+	entry.Submitted = submitted
+	entry.Values, err = stringMap(rc.Receive())
+	return entry, err
+}
+
+// entriesBatch is how many entries rangeEntries reads at a time when it
+// has to filter them.
+const entriesBatch = 500
+
+func (s *redisStore) Entries(id string, q EntryQuery) ([]Entry, error) {
+	rc := s.pool.Get()
+	defer rc.Close()
+
+	return rangeEntries(rc, key("form", id, "entries"), func(eid string) string {
+		return key("form", id, "entry", eid)
+	}, q)
+}
+
+// rangeEntries reads the entries in the index key matching q, with values
+// from the hashes named by k.
+func rangeEntries(rc redis.Conn, index string, k func(eid string) string, q EntryQuery) ([]Entry, error) {
+	var min, max interface{} = "-inf", "+inf"
+	if q.Since != 0 {
+		min = q.Since
+	}
+	if q.Until != 0 {
+		max = q.Until
+	}
+	// Entries submitted in the same second as the cursor are still read and
+	// skipped by q.matches.
+	if q.After.ID != "" {
+		if q.Oldest && (q.Since == 0 || q.After.Submitted > q.Since) {
+			min = q.After.Submitted
+		}
+		if !q.Oldest && (q.Until == 0 || q.After.Submitted < q.Until) {
+			max = q.After.Submitted
+		}
+	}
+	command, from, to := "ZREVRANGEBYSCORE", max, min
+	if q.Oldest {
+		command, from, to = "ZRANGEBYSCORE", min, max
+	}
+
+	batch := entriesBatch
+	if q.Limit > 0 && q.Limit < batch && q.After.ID == "" && len(q.Filters) == 0 {
+		batch = q.Limit
+	}
+
+	var entries []Entry
+	for offset := 0; ; offset += batch {
+		v, err := redis.Values(rc.Do(command,
+			index, from, to,
+			"WITHSCORES", "LIMIT", offset, batch,
+		))
+		if err != nil {
+			return nil, err
+		}
+
+		page := make([]Entry, len(v)/2)
+		for i := range page {
+			v, err = redis.Scan(v, &page[i].ID, &page[i].Submitted)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for _, entry := range page {
+			rc.Send("HGETALL", k(entry.ID))
+		}
+		rc.Flush()
+		for _, entry := range page {
+			values, err := stringMap(rc.Receive())
+			if err != nil {
+				return nil, err
+			}
+			entry.Values = values
+			if !q.matches(entry) {
+				continue
+			}
+			entries = append(entries, entry)
+			if q.Limit > 0 && len(entries) == q.Limit {
+				return entries, nil
+			}
+		}
+
+		if len(page) < batch {
+			return entries, nil
+		}
+	}
+}
+
+func spamKey(id string) func(eid string) string {
+	return func(eid string) string {
+		return key("form", id, "spam", eid)
+	}
+}
+
+func (s *redisStore) AddSpam(id string, entry Entry) error {
+	rc := s.pool.Get()
+	defer rc.Close()
+
+	return exec(rc, func() error {
+		k := spamKey(id)(entry.ID)
+		if len(entry.Values) > 0 {
+			if err := rc.Send("HMSET", redis.Args{k}.AddFlat(entry.Values)...); err != nil {
+				return err
+			}
+		}
+		return rc.Send("ZADD", key("form", id, "spam"), entry.Submitted, entry.ID)
+	})
+}
+
+func (s *redisStore) DeleteSpam(id, eid string) (Entry, error) {
+	rc := s.pool.Get()
+	defer rc.Close()
+
+	entry, err := readEntry(rc, key("form", id, "spam"), spamKey(id)(eid), eid)
+	if err != nil {
+		return entry, err
+	}
+	return entry, exec(rc, func() error {
+		if err := rc.Send("ZREM", key("form", id, "spam"), eid); err != nil {
+			return err
+		}
+		return rc.Send("DEL", spamKey(id)(eid))
+	})
+}
+
+func (s *redisStore) Spam(id string, q EntryQuery) ([]Entry, error) {
+	rc := s.pool.Get()
+	defer rc.Close()
+
+	return rangeEntries(rc, key("form", id, "spam"), spamKey(id), q)
+}
+
+// Repair scans formic:form:<id>:* for entries written before submissions
+// were atomic. Entry hashes missing from the index are indexed as of now
+// since their submission time is lost, and unregistered field names are
+// added to the fields set. Index members without a hash are entries with no
+// values and are left alone. Forms created before formic:forms existed are
+// added to it, and ones deleted before the trash existed are put in it.
+func (s *redisStore) Repair(dryRun bool) ([]string, error) {
+	rc := s.pool.Get()
+	defer rc.Close()
+
+	forms := make(map[string][]string)
+	cursor := "0"
+	for {
+		v, err := redis.Values(rc.Do(
+			"SCAN", cursor,
+			"MATCH", key("form", "*"),
+			"COUNT", 1000,
+		))
+		if err != nil {
+			return nil, err
+		}
+		var keys []string
+		if _, err = redis.Scan(v, &cursor, &keys); err != nil {
+			return nil, err
+		}
+		for _, k := range keys {
+			parts := strings.Split(k, ":")
+			if len(parts) < 3 {
+				continue
+			}
+			id := parts[2]
+			if _, ok := forms[id]; !ok {
+				forms[id] = nil
+			}
+			if len(parts) == 5 && parts[3] == "entry" {
+				forms[id] = append(forms[id], parts[4])
+			}
+		}
+		if cursor == "0" {
+			break
+		}
+	}
+
+	ids := make([]string, 0, len(forms))
+	for id := range forms {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	problems, err := s.repairTrash(rc, dryRun)
+	if err != nil {
+		return problems, err
+	}
+	for _, id := range ids {
+		p, err := s.repairForm(rc, id, forms[id], dryRun)
+		if err != nil {
+			return problems, err
+		}
+		problems = append(problems, p...)
+	}
+	return problems, nil
+}
+
+// repairTrash moves forms deleted before the trash existed, which are only
+// in their owner's formic:<uid>:deletedForms, into it as of now.
+func (s *redisStore) repairTrash(rc redis.Conn, dryRun bool) ([]string, error) {
+	var problems []string
+	cursor := "0"
+	for {
+		v, err := redis.Values(rc.Do(
+			"SCAN", cursor,
+			"MATCH", key("*", "deletedForms"),
+			"COUNT", 1000,
+		))
+		if err != nil {
+			return problems, err
+		}
+		var keys []string
+		if _, err = redis.Scan(v, &cursor, &keys); err != nil {
+			return problems, err
+		}
+		for _, k := range keys {
+			parts := strings.Split(k, ":")
+			if len(parts) != 3 {
+				continue
+			}
+			uid := parts[1]
+			ids, err := redis.Strings(rc.Do("SMEMBERS", k))
+			if err != nil {
+				return problems, err
+			}
+			sort.Strings(ids)
+			for _, id := range ids {
+				form, err := s.Form(id)
+				if err == ErrNotFound {
+					continue
+				}
+				if err != nil {
+					return problems, err
+				}
+				if form.Deleted != 0 {
+					continue
+				}
+				problems = append(problems, fmt.Sprintf(
+					"form %s: form was deleted but isn't in the trash", id,
+				))
+				if dryRun {
+					continue
+				}
+				form.Deleted = time.Now().UTC().Unix()
+				if form.Owner == "" {
+					form.Owner = uid
+				}
+				if err := s.SaveForm(form); err != nil {
+					return problems, err
+				}
+			}
+		}
+		if cursor == "0" {
+			break
+		}
+	}
+	return problems, nil
+}
+
+func (s *redisStore) repairForm(rc redis.Conn, id string, eids []string, dryRun bool) ([]string, error) {
+	var problems []string
+
+	exists, err := redis.Bool(rc.Do("EXISTS", key("form", id)))
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		problems = append(problems, fmt.Sprintf(
+			"form %s: form doesn't exist but has data (not repaired)", id,
+		))
+	}
+
+	if exists {
+		registered, err := redis.Bool(rc.Do("SISMEMBER", key("forms"), id))
+		if err != nil {
+			return nil, err
+		}
+		if !registered {
+			problems = append(problems, fmt.Sprintf(
+				"form %s: form is missing from the forms set", id,
+			))
+			if !dryRun {
+				if _, err := rc.Do("SADD", key("forms"), id); err != nil {
+					return problems, err
+				}
+			}
+		}
+	}
+
+	indexed, err := redis.Strings(rc.Do("ZRANGE", key("form", id, "entries"), 0, -1))
+	if err != nil {
+		return nil, err
+	}
+	fields, err := redis.Strings(rc.Do("SMEMBERS", key("form", id, "fields")))
+	if err != nil {
+		return nil, err
+	}
+
+	inIndex := make(map[string]bool, len(indexed))
+	for _, eid := range indexed {
+		inIndex[eid] = true
+	}
+
+	known := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		known[field] = true
+	}
+	now := time.Now().UTC().Unix()
+	for _, eid := range eids {
+		if !inIndex[eid] {
+			problems = append(problems, fmt.Sprintf(
+				"form %s: entry %s is missing from the index", id, eid,
+			))
+			if !dryRun {
+				_, err := rc.Do("ZADD", key("form", id, "entries"), now, eid)
+				if err != nil {
+					return problems, err
+				}
+			}
+		}
+
+		names, err := redis.Strings(rc.Do("HKEYS", key("form", id, "entry", eid)))
+		if err != nil {
+			return problems, err
+		}
+		for _, name := range names {
+			if known[name] {
+				continue
+			}
+			known[name] = true
+			problems = append(problems, fmt.Sprintf(
+				"form %s: field %q is missing from the fields set", id, name,
+			))
+			if !dryRun {
+				_, err := rc.Do("SADD", key("form", id, "fields"), name)
+				if err != nil {
+					return problems, err
+				}
+			}
+		}
+	}
+
+	return problems, nil
+}
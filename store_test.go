@@ -0,0 +1,467 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// storeTests run against every store. Each one uses forms of its own, so
+// stores don't need to be empty.
+var storeTests = []struct {
+	name string
+	fn   func(t *testing.T, s FormStore)
+}{
+	{"Forms", testStoreForms},
+	{"Entries", testStoreEntries},
+	{"EntryPages", testStoreEntryPages},
+	{"Roles", testStoreRoles},
+	{"Trash", testStoreTrash},
+}
+
+func testStore(t *testing.T, s FormStore) {
+	for _, test := range storeTests {
+		test := test
+		t.Run(test.name, func(t *testing.T) { test.fn(t, s) })
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, newMemoryStore())
+}
+
+func TestBoltStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "formic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := newBoltStore(filepath.Join(dir, "formic.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.db.Close()
+	testStore(t, s)
+}
+
+// TestRedisStore needs a Redis server, e.g. FORMIC_TEST_REDIS=localhost:6379.
+func TestRedisStore(t *testing.T) {
+	s := testRedisStore(t)
+	defer s.pool.Close()
+	testStore(t, s)
+}
+
+// TestPostgresStore needs a database, e.g.
+// FORMIC_TEST_POSTGRES=postgres://localhost/formic_test?sslmode=disable.
+func TestPostgresStore(t *testing.T) {
+	url := os.Getenv("FORMIC_TEST_POSTGRES")
+	if url == "" {
+		t.Skip("FORMIC_TEST_POSTGRES isn't set")
+	}
+	s, err := newPostgresStore(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.db.Close()
+	testStore(t, s)
+}
+
+func TestRedisRepair(t *testing.T) {
+	s := testRedisStore(t)
+	defer s.pool.Close()
+
+	// An entry without values only has its index member.
+	id := genID()
+	if err := s.SaveForm(Form{ID: id, Name: "Repair"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddEntry(id, Entry{ID: "empty", Submitted: 1000}); err != nil {
+		t.Fatal(err)
+	}
+	rc := s.pool.Get()
+	_, err := rc.Do("HMSET", key("form", id, "entry", "lost"), "name", "Mark")
+	rc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Repair(false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Entry(id, "empty"); err != nil {
+		t.Errorf("entry without values: %v", err)
+	}
+	entry, err := s.Entry(id, "lost")
+	if err != nil || entry.Values["name"] != "Mark" {
+		t.Errorf("unindexed entry = %+v, %v; want it indexed", entry, err)
+	}
+	if fields, _ := s.Fields(id); !reflect.DeepEqual(fields, []string{"name"}) {
+		t.Errorf("Fields = %v; want [name]", fields)
+	}
+}
+
+func TestRedisRepairTrash(t *testing.T) {
+	s := testRedisStore(t)
+	defer s.pool.Close()
+
+	// Forms used to be deleted by only moving them to deletedForms.
+	uid := "user-" + genID()
+	id := genID()
+	if err := s.SaveForm(Form{ID: id, Name: "Old"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddUserForm(uid, id); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteUserForm(uid, id); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Repair(false); err != nil {
+		t.Fatal(err)
+	}
+	form, err := s.Form(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if form.Deleted == 0 || form.Owner != uid {
+		t.Errorf("form = %+v; want it deleted and owned by %s", form, uid)
+	}
+	if ids, _ := s.TrashedForms(form.Deleted); !hasID(ids, id) {
+		t.Errorf("TrashedForms = %v; want %s in them", ids, id)
+	}
+}
+
+func testRedisStore(t *testing.T) *redisStore {
+	addr := os.Getenv("FORMIC_TEST_REDIS")
+	if addr == "" {
+		t.Skip("FORMIC_TEST_REDIS isn't set")
+	}
+	return newRedisStore(&redis.Pool{
+		MaxIdle: 3,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", addr)
+		},
+	})
+}
+
+func testIDs(entries []Entry) []string {
+	ids := make([]string, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.ID
+	}
+	return ids
+}
+
+func hasID(ids []string, id string) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+func testStoreForms(t *testing.T, s FormStore) {
+	uid := "user-" + genID()
+	form := Form{ID: genID(), Owner: uid, Name: "Contact", RedirectURL: "http://example.com"}
+
+	if _, err := s.Form(form.ID); err != ErrNotFound {
+		t.Errorf("Form before saving: %v; want ErrNotFound", err)
+	}
+	if err := s.SaveForm(form); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddUserForm(uid, form.ID); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Form(form.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != form {
+		t.Errorf("Form = %+v; want %+v", got, form)
+	}
+	if ids, err := s.Forms(); err != nil || !hasID(ids, form.ID) {
+		t.Errorf("Forms = %v, %v; want %s in them", ids, err, form.ID)
+	}
+	if ids, err := s.UserForms(uid); err != nil || !reflect.DeepEqual(ids, []string{form.ID}) {
+		t.Errorf("UserForms = %v, %v; want [%s]", ids, err, form.ID)
+	}
+	if role, err := s.FormRole(form.ID, uid, "owner@example.com"); err != nil || role != RoleOwner {
+		t.Errorf("FormRole of owner = %q, %v; want %q", role, err, RoleOwner)
+	}
+	if role, err := s.FormRole(form.ID, "someone", "someone@example.com"); err != nil || role != "" {
+		t.Errorf("FormRole of someone else = %q, %v; want none", role, err)
+	}
+
+	if err := s.DeleteUserForm(uid, form.ID); err != nil {
+		t.Fatal(err)
+	}
+	if ids, _ := s.UserForms(uid); len(ids) != 0 {
+		t.Errorf("UserForms after deleting = %v; want none", ids)
+	}
+	if ids, _ := s.DeletedUserForms(uid); !reflect.DeepEqual(ids, []string{form.ID}) {
+		t.Errorf("DeletedUserForms = %v; want [%s]", ids, form.ID)
+	}
+	if err := s.RestoreUserForm(uid, form.ID); err != nil {
+		t.Fatal(err)
+	}
+	if ids, _ := s.UserForms(uid); !reflect.DeepEqual(ids, []string{form.ID}) {
+		t.Errorf("UserForms after restoring = %v; want [%s]", ids, form.ID)
+	}
+	if ids, _ := s.DeletedUserForms(uid); len(ids) != 0 {
+		t.Errorf("DeletedUserForms after restoring = %v; want none", ids)
+	}
+}
+
+func testStoreEntries(t *testing.T, s FormStore) {
+	id := genID()
+	entries := []Entry{
+		{"a", -100, map[string]string{"name": "Old", "city": "Manila"}},
+		{"b", -50, map[string]string{"name": "Older"}},
+		{"c", 0, map[string]string{"name": "Epoch"}},
+		{"d", 1000, map[string]string{"name": "Mark", "email": "mark@example.com"}},
+		{"e", 2000, map[string]string{"name": "Steve"}},
+	}
+	for _, entry := range entries {
+		if err := s.AddEntry(id, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entry, err := s.Entry(id, "d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(entry, entries[3]) {
+		t.Errorf("Entry = %+v; want %+v", entry, entries[3])
+	}
+	if _, err := s.Entry(id, "nope"); err != ErrNotFound {
+		t.Errorf("Entry of a missing entry: %v; want ErrNotFound", err)
+	}
+	fields, err := s.Fields(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != 3 || !hasID(fields, "name") || !hasID(fields, "city") || !hasID(fields, "email") {
+		t.Errorf("Fields = %v; want city, email and name", fields)
+	}
+
+	queries := []struct {
+		q    EntryQuery
+		want []string
+	}{
+		{EntryQuery{}, []string{"e", "d", "c", "b", "a"}},
+		{EntryQuery{Oldest: true}, []string{"a", "b", "c", "d", "e"}},
+		{EntryQuery{Since: -50, Until: 1000}, []string{"d", "c", "b"}},
+		{EntryQuery{Since: -50, Until: 1000, Oldest: true}, []string{"b", "c", "d"}},
+		{EntryQuery{Until: -1}, []string{"b", "a"}},
+		{EntryQuery{Since: 1, Oldest: true}, []string{"d", "e"}},
+		{EntryQuery{Limit: 2}, []string{"e", "d"}},
+		{EntryQuery{Limit: 2, Oldest: true}, []string{"a", "b"}},
+		{EntryQuery{Filters: map[string]string{"name": "OLD"}}, []string{"b", "a"}},
+	}
+	for _, test := range queries {
+		got, err := s.Entries(id, test.q)
+		if err != nil {
+			t.Errorf("Entries(%+v): %s", test.q, err.Error())
+			continue
+		}
+		if ids := testIDs(got); !reflect.DeepEqual(ids, test.want) {
+			t.Errorf("Entries(%+v) = %v; want %v", test.q, ids, test.want)
+		}
+	}
+
+	// Changing what's returned doesn't change the stored entry.
+	entry.Values["name"] = "Changed"
+	if got, _ := s.Entry(id, "d"); got.Values["name"] != "Mark" {
+		t.Errorf("stored entry changed to %+v", got)
+	}
+	if got, _ := s.Entries(id, EntryQuery{Limit: 1}); len(got) == 1 {
+		got[0].Values["name"] = "Changed"
+	}
+	if got, _ := s.Entry(id, "e"); got.Values["name"] != "Steve" {
+		t.Errorf("stored entry changed to %+v", got)
+	}
+
+	entry.Values = map[string]string{"name": "Mark", "email": ""}
+	if err := s.UpdateEntry(id, entry); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.Entry(id, "d"); !reflect.DeepEqual(got, entry) {
+		t.Errorf("Entry after updating = %+v; want %+v", got, entry)
+	}
+
+	if err := s.DeleteEntry(id, "d"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Entry(id, "d"); err != ErrNotFound {
+		t.Errorf("Entry after deleting: %v; want ErrNotFound", err)
+	}
+	if got, _ := s.Entries(id, EntryQuery{}); !reflect.DeepEqual(testIDs(got), []string{"e", "c", "b", "a"}) {
+		t.Errorf("Entries after deleting = %v", testIDs(got))
+	}
+}
+
+// testStoreEntryPages pages through entries one at a time in both
+// directions, including ones submitted in the same second and before 1970.
+func testStoreEntryPages(t *testing.T, s FormStore) {
+	id := genID()
+	// IDs are out of order so entries only come out right sorted by time.
+	entries := []Entry{
+		{"05", -100, nil}, {"07", -100, nil}, {"03", -50, nil}, {"06", 0, nil},
+		{"00", 1000, nil}, {"01", 1000, nil}, {"04", 1000, nil}, {"02", 2000, nil},
+	}
+	var want []string
+	for i, entry := range entries {
+		entry.Values = map[string]string{"n": fmt.Sprint(i)}
+		if err := s.AddEntry(id, entry); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, entry.ID)
+	}
+
+	for _, oldest := range []bool{true, false} {
+		var ids []string
+		q := EntryQuery{Limit: 1, Oldest: oldest}
+		for i := 0; i <= len(want); i++ {
+			page, err := s.Entries(id, q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(page) == 0 {
+				break
+			}
+			ids = append(ids, page[0].ID)
+			q.After = page[0].Cursor()
+		}
+		if !oldest {
+			for i, j := 0, len(ids)-1; i < j; i, j = i+1, j-1 {
+				ids[i], ids[j] = ids[j], ids[i]
+			}
+		}
+		if !reflect.DeepEqual(ids, want) {
+			t.Errorf("paging with Oldest %v got %v; want %v", oldest, ids, want)
+		}
+	}
+
+	q := EntryQuery{Since: -100, Until: 1000, After: EntryCursor{1000, "04"}}
+	got, err := s.Entries(id, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := testIDs(got); !reflect.DeepEqual(ids, []string{"01", "00", "06", "03", "07", "05"}) {
+		t.Errorf("Entries(%+v) = %v", q, ids)
+	}
+}
+
+func testStoreRoles(t *testing.T, s FormStore) {
+	uid := "user-" + genID()
+	form := Form{ID: genID(), Owner: uid, Name: "Shared"}
+	if err := s.SaveForm(form); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddUserForm(uid, form.ID); err != nil {
+		t.Fatal(err)
+	}
+	collaborators := []Collaborator{
+		{"editor@example.com", RoleEditor},
+		{"owner@example.com", RoleViewer},
+	}
+	for _, c := range collaborators {
+		if err := s.SetCollaborator(form.ID, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	roles := []struct {
+		uid, email, want string
+	}{
+		// Owners stay owners even if they're listed as collaborators.
+		{uid, "owner@example.com", RoleOwner},
+		{"other", "owner@example.com", RoleViewer},
+		{"other", "editor@example.com", RoleEditor},
+		{"other", "other@example.com", ""},
+	}
+	for _, test := range roles {
+		if role, err := s.FormRole(form.ID, test.uid, test.email); err != nil || role != test.want {
+			t.Errorf("FormRole(%s, %s) = %q, %v; want %q", test.uid, test.email, role, err, test.want)
+		}
+	}
+
+	if got, err := s.Collaborators(form.ID); err != nil || !reflect.DeepEqual(got, collaborators) {
+		t.Errorf("Collaborators = %v, %v; want %v", got, err, collaborators)
+	}
+	if ids, err := s.SharedForms("editor@example.com"); err != nil || !reflect.DeepEqual(ids, []string{form.ID}) {
+		t.Errorf("SharedForms = %v, %v; want [%s]", ids, err, form.ID)
+	}
+	if err := s.RemoveCollaborator(form.ID, "editor@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if role, _ := s.FormRole(form.ID, "other", "editor@example.com"); role != "" {
+		t.Errorf("FormRole after removing = %q; want none", role)
+	}
+	if ids, _ := s.SharedForms("editor@example.com"); len(ids) != 0 {
+		t.Errorf("SharedForms after removing = %v; want none", ids)
+	}
+}
+
+func testStoreTrash(t *testing.T, s FormStore) {
+	uid := "user-" + genID()
+	form := Form{ID: genID(), Owner: uid, Name: "Trashed", Deleted: 1000}
+	if err := s.SaveForm(form); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddUserForm(uid, form.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteUserForm(uid, form.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddEntry(form.ID, Entry{"e", 500, map[string]string{"name": "Mark"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetCollaborator(form.ID, Collaborator{"editor@example.com", RoleEditor}); err != nil {
+		t.Fatal(err)
+	}
+
+	if ids, _ := s.TrashedForms(999); hasID(ids, form.ID) {
+		t.Errorf("TrashedForms(999) = %v; want %s not in them", ids, form.ID)
+	}
+	if ids, _ := s.TrashedForms(1000); !hasID(ids, form.ID) {
+		t.Errorf("TrashedForms(1000) = %v; want %s in them", ids, form.ID)
+	}
+
+	form.Deleted = 0
+	if err := s.SaveForm(form); err != nil {
+		t.Fatal(err)
+	}
+	if ids, _ := s.TrashedForms(1000); hasID(ids, form.ID) {
+		t.Errorf("TrashedForms after restoring = %v; want %s not in them", ids, form.ID)
+	}
+
+	if err := s.PurgeForm(form); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Form(form.ID); err != ErrNotFound {
+		t.Errorf("Form after purging: %v; want ErrNotFound", err)
+	}
+	if _, err := s.Entry(form.ID, "e"); err != ErrNotFound {
+		t.Errorf("Entry after purging: %v; want ErrNotFound", err)
+	}
+	if ids, _ := s.Forms(); hasID(ids, form.ID) {
+		t.Errorf("Forms after purging = %v; want %s not in them", ids, form.ID)
+	}
+	if ids, _ := s.DeletedUserForms(uid); len(ids) != 0 {
+		t.Errorf("DeletedUserForms after purging = %v; want none", ids)
+	}
+	if ids, _ := s.SharedForms("editor@example.com"); hasID(ids, form.ID) {
+		t.Errorf("SharedForms after purging = %v; want %s not in them", ids, form.ID)
+	}
+}
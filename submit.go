@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+var errEntryTooLarge = errors.New("Entry is too large")
+
+// parseJSONSubmission reads a JSON object into req.PostForm, naming nested
+// objects and arrays the way HTML forms would, e.g. address[city] and
+// tags[].
+func parseJSONSubmission(w http.ResponseWriter, req *http.Request) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxFormSize))
+	dec.UseNumber()
+
+	var obj map[string]interface{}
+	if err := dec.Decode(&obj); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return errEntryTooLarge
+		}
+		return errors.New("Entry isn't a JSON object")
+	}
+
+	req.PostForm = url.Values{}
+	for name, v := range obj {
+		addJSONValue(req.PostForm, name, v)
+	}
+	return nil
+}
+
+func addJSONValue(values url.Values, name string, v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			addJSONValue(values, name+"["+key+"]", child)
+		}
+	case []interface{}:
+		if !strings.HasSuffix(name, "[]") {
+			name += "[]"
+		}
+		for _, item := range v {
+			addJSONValue(values, name, item)
+		}
+	case json.Number:
+		values.Add(name, v.String())
+	case bool:
+		values.Add(name, strconv.FormatBool(v))
+	case string:
+		values.Add(name, v)
+	case nil:
+		values.Add(name, "")
+	}
+}
+
+// wantsJSON reports whether a submission came from a script asking for a
+// JSON result rather than a redirect.
+func wantsJSON(req *http.Request) bool {
+	for _, accept := range strings.Split(req.Header.Get("Accept"), ",") {
+		t, _, _ := mime.ParseMediaType(strings.TrimSpace(accept))
+		if t == "application/json" {
+			return true
+		}
+	}
+	return false
+}
+
+type submitResult struct {
+	ID     string      `json:"id,omitempty"`
+	Error  string      `json:"error,omitempty"`
+	Fields FieldErrors `json:"fields,omitempty"`
+}
+
+// rejectEntry answers a submission that couldn't be saved, listing what's
+// wrong with each field if errs is set.
+func rejectEntry(w http.ResponseWriter, req *http.Request, form Form, status int, msg string, errs FieldErrors) {
+	if wantsJSON(req) {
+		r.JSON(w, status, submitResult{Error: msg, Fields: errs})
+		return
+	}
+	if errs != nil {
+		r.HTML(w, status, "invalid", map[string]interface{}{
+			"Form":   form,
+			"Errors": errs,
+		})
+		return
+	}
+	http.Error(w, msg, status)
+}
+
+// acceptEntry answers a submission that was saved, either with its ID or by
+// redirecting to the form's redirect URL.
+func acceptEntry(w http.ResponseWriter, req *http.Request, form Form, entry Entry) {
+	if wantsJSON(req) {
+		r.JSON(w, http.StatusCreated, submitResult{ID: entry.ID})
+		return
+	}
+	http.Redirect(w, req, form.RedirectURL, http.StatusFound)
+}
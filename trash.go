@@ -0,0 +1,135 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/zenazn/goji/web"
+)
+
+type TrashedForm struct {
+	Form
+	Purge int64
+}
+
+func purgeDate(form Form) int64 {
+	return time.Unix(form.Deleted, 0).AddDate(0, 0, *trashRetentionDays).Unix()
+}
+
+func showTrash(c web.C, w http.ResponseWriter, req *http.Request) {
+	var (
+		forms []TrashedForm
+		err   error
+	)
+
+	uid := c.Env["uid"].(string)
+
+	defer func() {
+		if err != nil {
+			http.Error(w, "Error showing trash: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}()
+
+	fids, err := store.DeletedUserForms(uid)
+	if err != nil {
+		return
+	}
+
+	for _, fid := range fids {
+		var form Form
+		form, err = store.Form(fid)
+		if err == ErrNotFound {
+			err = nil
+			continue
+		}
+		if err != nil {
+			return
+		}
+		forms = append(forms, TrashedForm{form, purgeDate(form)})
+	}
+
+	r.HTML(w, http.StatusOK, "trash", map[string]interface{}{
+		"Forms":         forms,
+		"RetentionDays": *trashRetentionDays,
+		"Messages":      getMessages(c, w, req),
+	})
+}
+
+func restoreForm(c web.C, w http.ResponseWriter, req *http.Request) {
+	var err error
+
+	session := c.Env["session"].(*sessions.Session)
+	uid := c.Env["uid"].(string)
+	id := c.URLParams["id"]
+
+	defer func() {
+		if err != nil {
+			http.Error(w, "Error restoring form: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}()
+
+	fids, err := store.DeletedUserForms(uid)
+	if err != nil {
+		return
+	}
+	trashed := false
+	for _, fid := range fids {
+		if fid == id {
+			trashed = true
+			break
+		}
+	}
+
+	form, err := store.Form(id)
+	if err == ErrNotFound || !trashed {
+		err = nil
+		http.Error(w, "Form doesn't exist", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	form.Deleted = 0
+	if err = store.SaveForm(form); err != nil {
+		return
+	}
+	if err = store.RestoreUserForm(uid, id); err != nil {
+		return
+	}
+
+	session.AddFlash("Form restored", "success")
+	session.Save(req, w)
+	http.Redirect(w, req, "/dashboard/"+id, http.StatusFound)
+}
+
+// purgeTrash permanently deletes forms that have been in the trash for
+// longer than trash-retention-days.
+func purgeTrash() error {
+	before := time.Now().UTC().AddDate(0, 0, -*trashRetentionDays).Unix()
+	ids, err := store.TrashedForms(before)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		form, err := store.Form(id)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err = blobs.DeletePrefix(form.ID + "/"); err != nil {
+			return err
+		}
+		if err = store.PurgeForm(form); err != nil {
+			return err
+		}
+		log.Printf("Purged form %s", id)
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Fields posted more than once, or with names ending in [], keep all their
+// values as one entry value starting with listPrefix.
+const listPrefix = "formic-list:"
+
+func listValue(values []string, multi bool) string {
+	if len(values) == 1 && !multi {
+		return values[0]
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(values)
+	return listPrefix + strings.TrimSpace(buf.String())
+}
+
+// parseList returns the values an entry value holds.
+func parseList(value string) []string {
+	if !strings.HasPrefix(value, listPrefix) {
+		return []string{value}
+	}
+	var values []string
+	if err := json.Unmarshal([]byte(value[len(listPrefix):]), &values); err != nil {
+		return []string{value}
+	}
+	return values
+}
+
+func isList(value string) bool {
+	return strings.HasPrefix(value, listPrefix)
+}
+
+// reservedValue reports whether a value starts like the attachments and
+// lists Formic stores itself. Submitted, imported and edited values can't,
+// so they can't pass for another entry's files.
+func reservedValue(value string) bool {
+	return strings.HasPrefix(value, attachmentPrefix) || isList(value)
+}
+
+// checkReserved adds an error to errs for each field with a reserved value.
+func checkReserved(errs FieldErrors, values url.Values) FieldErrors {
+	for field, vals := range values {
+		for _, value := range vals {
+			if reservedValue(value) {
+				if errs == nil {
+					errs = make(FieldErrors)
+				}
+				errs[field] = "isn't valid"
+			}
+		}
+	}
+	return errs
+}
+
+// fieldName turns bracketed names like address[city] into the dotted
+// address.city fields are stored as. multi is set for names ending in [].
+func fieldName(name string) (field string, multi bool) {
+	i := strings.Index(name, "[")
+	if i <= 0 || !strings.HasSuffix(name, "]") {
+		return name, false
+	}
+	parts := []string{name[:i]}
+	for _, part := range strings.Split(name[i+1:len(name)-1], "][") {
+		if strings.ContainsAny(part, "[]") {
+			return name, false
+		}
+		parts = append(parts, part)
+	}
+	if parts[len(parts)-1] == "" {
+		parts, multi = parts[:len(parts)-1], true
+	}
+	for _, part := range parts {
+		if part == "" {
+			return name, false
+		}
+	}
+	return strings.Join(parts, "."), multi
+}
+
+// fieldValues groups posted values by field name, merging tags and tags[].
+// Fields posted with [] are listed in multi so they stay lists even with a
+// single value.
+func fieldValues(posted url.Values) (values url.Values, multi map[string]bool) {
+	values = url.Values{}
+	multi = make(map[string]bool)
+	names := make([]string, 0, len(posted))
+	for name := range posted {
+		names = append(names, name)
+	}
+	// Keep the order stable when both tags and tags[] are posted.
+	sort.Strings(names)
+	for _, name := range names {
+		field, m := fieldName(name)
+		values[field] = append(values[field], posted[name]...)
+		if m {
+			multi[field] = true
+		}
+	}
+	return values, multi
+}
+
+// displayValue shows lists separated by commas and attachments by their
+// file name.
+func displayValue(value string) string {
+	items := parseList(value)
+	for i, item := range items {
+		if a := parseAttachment(item); a != nil {
+			items[i] = a.Name
+		}
+	}
+	return strings.Join(items, ", ")
+}
+
+// fieldLabel titles dotted field names, e.g. Address › City.
+func fieldLabel(field string) string {
+	parts := strings.Split(field, ".")
+	for i, part := range parts {
+		parts[i] = strings.Title(part)
+	}
+	return strings.Join(parts, " › ")
+}
+
+// nestValues turns dotted fields back into nested objects and lists into
+// arrays, for JSON. Fields clashing with others' parents stay dotted.
+func nestValues(values map[string]string) map[string]interface{} {
+	fields := make([]string, 0, len(values))
+	for field := range values {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	nested := make(map[string]interface{})
+	for _, field := range fields {
+		value := values[field]
+		var v interface{} = displayValue(value)
+		if isList(value) {
+			items := parseList(value)
+			for i, item := range items {
+				items[i] = displayValue(item)
+			}
+			v = items
+		}
+
+		parts := strings.Split(field, ".")
+		m := nested
+		for _, part := range parts[:len(parts)-1] {
+			child, ok := m[part].(map[string]interface{})
+			if !ok {
+				if _, taken := m[part]; taken {
+					m = nil
+					break
+				}
+				child = make(map[string]interface{})
+				m[part] = child
+			}
+			m = child
+		}
+		last := parts[len(parts)-1]
+		if _, taken := m[last]; m == nil || taken {
+			nested[field] = v
+			continue
+		}
+		m[last] = v
+	}
+	return nested
+}
+
+// entryLines shows lists one value per line for editing.
+func entryLines(value string) string {
+	return strings.Join(parseList(value), "\n")
+}
+
+// splitLines reads edited lists back, dropping blank lines.
+func splitLines(text string) []string {
+	var values []string
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			values = append(values, line)
+		}
+	}
+	return values
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestFieldName(t *testing.T) {
+	tests := []struct {
+		name  string
+		field string
+		multi bool
+	}{
+		{"email", "email", false},
+		{"tags[]", "tags", true},
+		{"address[city]", "address.city", false},
+		{"address[lines][]", "address.lines", true},
+		{"a[b][c]", "a.b.c", false},
+		{"[city]", "[city]", false},
+		{"address[]city", "address[]city", false},
+		{"address[][city]", "address[][city]", false},
+		{"address[ci[ty]", "address[ci[ty]", false},
+	}
+	for _, test := range tests {
+		field, multi := fieldName(test.name)
+		if field != test.field || multi != test.multi {
+			t.Errorf("fieldName(%q) = %q, %v; want %q, %v", test.name, field, multi, test.field, test.multi)
+		}
+	}
+}
+
+func TestFieldValues(t *testing.T) {
+	values, multi := fieldValues(url.Values{
+		"tags":          {"a"},
+		"tags[]":        {"b", "c"},
+		"address[city]": {"Manila"},
+		"name":          {"Mark"},
+	})
+	want := url.Values{
+		"tags":         {"a", "b", "c"},
+		"address.city": {"Manila"},
+		"name":         {"Mark"},
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("values = %v; want %v", values, want)
+	}
+	if !reflect.DeepEqual(multi, map[string]bool{"tags": true}) {
+		t.Errorf("multi = %v; want only tags", multi)
+	}
+}
+
+func TestCheckReserved(t *testing.T) {
+	errs := checkReserved(nil, url.Values{
+		"name": {"Mark"},
+		"file": {attachmentPrefix + `{"Key":"other/entry/file"}`},
+		"tags": {"a", listPrefix + `["b"]`},
+	})
+	want := FieldErrors{"file": "isn't valid", "tags": "isn't valid"}
+	if !reflect.DeepEqual(errs, want) {
+		t.Errorf("errs = %v; want %v", errs, want)
+	}
+	if errs := checkReserved(nil, url.Values{"name": {"Mark"}}); errs != nil {
+		t.Errorf("errs = %v; want nil", errs)
+	}
+}